@@ -0,0 +1,382 @@
+package dyntree
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+const (
+	// sahBins is how many buckets centroids are sorted into along each axis when looking for a split
+	sahBins = 16
+	// sahTraversalCost is the cost of visiting a branch relative to testing a single entity
+	sahTraversalCost = 1.0
+	// parallelBuildThreshold is the smallest set of entities worth handing to another goroutine
+	parallelBuildThreshold = 4096
+)
+
+// buildPrim caches everything a builder needs to know about an entity so it's only asked once
+type buildPrim struct {
+	entity   Entity
+	box      BoundingBox
+	centroid Vec3
+}
+
+// buildNode is the hierarchy a builder produces before it's laid out on the tree's Nodes and Buckets
+type buildNode struct {
+	box   BoundingBox
+	left  *buildNode
+	right *buildNode
+	prims []buildPrim
+
+	// cost is the SAH cost of the subtree, only kept up to date while restructuring treelets
+	cost float64
+}
+
+func (b *buildNode) IsLeaf() bool {
+	return b.left == nil
+}
+
+type sahBin struct {
+	box   BoundingBox
+	count int
+}
+
+func (v Vec3) axis(a Axis) float64 {
+	switch a {
+	case X:
+		return v.X
+	case Y:
+		return v.Y
+	default:
+		return v.Z
+	}
+}
+
+// BuildTree creates a tree holding every entity in one go, using a binned surface area heuristic to pick splits.
+// This is both faster and gives a better tree than adding the entities one at a time, and the result can be
+// updated incrementally like any other tree afterwards. An entity listed more than once is only added once.
+func BuildTree(entities []Entity, opts ...Option) *Tree {
+	t := NewTree(opts...)
+	t.emitBuild(t.buildHierarchy(t.buildPrims(entities)))
+	return t
+}
+
+// BuildTreeParallel is BuildTree with independent subtrees built on as many goroutines as GOMAXPROCS allows.
+// Any WithBuildWorkers in opts takes precedence.
+func BuildTreeParallel(entities []Entity, opts ...Option) *Tree {
+	return BuildTree(entities, append([]Option{WithBuildWorkers(runtime.GOMAXPROCS(0))}, opts...)...)
+}
+
+func (t *Tree) buildHierarchy(prims []buildPrim) *buildNode {
+	if t.buildWorkers <= 1 {
+		return t.buildSAH(prims)
+	}
+
+	// The calling goroutine is already one of the workers
+	return t.buildSAHParallel(prims, make(chan struct{}, t.buildWorkers-1))
+}
+
+// buildPrims skips repeated entities, since an entity can only be mapped to a single leaf
+func (t *Tree) buildPrims(entities []Entity) []buildPrim {
+	seen := make(map[Entity]bool, len(entities))
+	prims := make([]buildPrim, 0, len(entities))
+	for _, e := range entities {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+
+		box := t.FatBox(e)
+		prims = append(prims, buildPrim{
+			entity:   e,
+			box:      box,
+			centroid: box.Min.Add(box.Max).Scale(0.5),
+		})
+	}
+	return prims
+}
+
+func (t *Tree) buildSAH(prims []buildPrim) *buildNode {
+	n, mid := t.splitSAH(prims)
+
+	if mid > 0 {
+		n.left = t.buildSAH(prims[:mid])
+		n.right = t.buildSAH(prims[mid:])
+	}
+
+	return n
+}
+
+// buildSAHParallel builds the same hierarchy as buildSAH, handing one half of each large enough split to another
+// goroutine whenever a slot in sem is free
+func (t *Tree) buildSAHParallel(prims []buildPrim, sem chan struct{}) *buildNode {
+	if len(prims) < parallelBuildThreshold {
+		return t.buildSAH(prims)
+	}
+
+	n, mid := t.splitSAH(prims)
+	if mid == 0 {
+		return n
+	}
+
+	select {
+	case sem <- struct{}{}:
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.left = t.buildSAHParallel(prims[:mid], sem)
+			<-sem
+		}()
+		n.right = t.buildSAHParallel(prims[mid:], sem)
+		wg.Wait()
+	default:
+		n.left = t.buildSAHParallel(prims[:mid], sem)
+		n.right = t.buildSAHParallel(prims[mid:], sem)
+	}
+
+	return n
+}
+
+// splitSAH bounds prims and partitions them in place at the cheapest split, returning where the right half
+// starts or 0 if they should stay together in a leaf
+func (t *Tree) splitSAH(prims []buildPrim) (*buildNode, int) {
+	n := &buildNode{prims: prims}
+	if len(prims) == 0 {
+		return n, 0
+	}
+
+	n.box = prims[0].box
+	centroids := BoundingBox{prims[0].centroid, prims[0].centroid}
+	for _, p := range prims[1:] {
+		n.box = n.box.Expand(p.box)
+		centroids = centroids.Expand(BoundingBox{p.centroid, p.centroid})
+	}
+
+	if len(prims) == 1 {
+		return n, 0
+	}
+
+	axis, plane, cost := t.findSAHSplit(prims, n.box, centroids)
+
+	// Splitting isn't worth it if testing everything in one leaf is cheaper, as long as it fits in one
+	if len(prims) <= t.maxLeaves && float64(len(prims)) <= cost {
+		return n, 0
+	}
+
+	mid := 0
+	if plane > 0 {
+		lo := centroids.Min.axis(axis)
+		scale := sahBins / (centroids.Max.axis(axis) - lo)
+		for i := range prims {
+			if sahBinIndex(prims[i].centroid.axis(axis), lo, scale) < plane {
+				prims[i], prims[mid] = prims[mid], prims[i]
+				mid++
+			}
+		}
+	}
+
+	// Every centroid landed on the same side, so fall back to halving the set
+	if mid == 0 || mid == len(prims) {
+		mid = len(prims) / 2
+	}
+
+	n.prims = nil
+
+	return n, mid
+}
+
+// findSAHSplit returns the axis and bin boundary giving the cheapest split, with a plane of 0 when the centroids
+// are all in the same spot and can't be binned at all
+func (t *Tree) findSAHSplit(prims []buildPrim, box, centroids BoundingBox) (axis Axis, plane int, cost float64) {
+	cost = math.Inf(1)
+
+	var bins [sahBins]sahBin
+	var rightArea [sahBins]float64
+	var rightCount [sahBins]int
+
+	parentArea := box.SurfaceArea()
+
+	for _, a := range []Axis{X, Y, Z} {
+		lo, hi := centroids.Min.axis(a), centroids.Max.axis(a)
+		if hi <= lo {
+			continue
+		}
+		scale := sahBins / (hi - lo)
+
+		bins = [sahBins]sahBin{}
+		for _, p := range prims {
+			b := &bins[sahBinIndex(p.centroid.axis(a), lo, scale)]
+			if b.count == 0 {
+				b.box = p.box
+			} else {
+				b.box = b.box.Expand(p.box)
+			}
+			b.count++
+		}
+
+		// Sweep from the right to know the cost of everything past each plane, then from the left to price them
+		acc, count := BoundingBox{}, 0
+		for i := sahBins - 1; i > 0; i-- {
+			acc, count = accumulateBin(acc, count, bins[i])
+			rightArea[i] = acc.SurfaceArea()
+			rightCount[i] = count
+		}
+
+		acc, count = BoundingBox{}, 0
+		for i := 1; i < sahBins; i++ {
+			acc, count = accumulateBin(acc, count, bins[i-1])
+			if count == 0 || rightCount[i] == 0 {
+				continue
+			}
+
+			c := sahTraversalCost
+			if parentArea > 0 {
+				c += (acc.SurfaceArea()*float64(count) + rightArea[i]*float64(rightCount[i])) / parentArea
+			}
+
+			if c < cost {
+				axis, plane, cost = a, i, c
+			}
+		}
+	}
+
+	return
+}
+
+func accumulateBin(acc BoundingBox, count int, b sahBin) (BoundingBox, int) {
+	if b.count == 0 {
+		return acc, count
+	}
+	if count == 0 {
+		return b.box, b.count
+	}
+	return acc.Expand(b.box), count + b.count
+}
+
+func sahBinIndex(v, lo, scale float64) int {
+	i := int((v - lo) * scale)
+	if i >= sahBins {
+		i = sahBins - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// emitBuild lays a built hierarchy out on a freshly created tree, starting from its root
+func (t *Tree) emitBuild(b *buildNode) {
+	t.emitNode(b, t.rootNode, 0)
+}
+
+func (t *Tree) emitNode(b *buildNode, n *Node, depth int) {
+	n.Box = b.box
+	n.Depth = depth
+
+	if t.maxDepth < depth {
+		t.maxDepth = depth
+	}
+
+	if b.IsLeaf() {
+		bucket := make([]Entity, len(b.prims))
+		for i, p := range b.prims {
+			bucket[i] = p.entity
+			t.MapLeaf(p.entity, n)
+		}
+		t.Buckets[n.BucketIndex-1] = bucket
+		return
+	}
+
+	if n.IsLeaf() {
+		t.FreeBucket(n)
+	}
+
+	n.Left = t.CreateNode(-1)
+	n.Left.Parent = n
+	t.emitNode(b.left, n.Left, depth+1)
+
+	n.Right = t.CreateNode(-1)
+	n.Right.Parent = n
+	t.emitNode(b.right, n.Right, depth+1)
+}
+
+// SAHCost returns the surface area heuristic cost of the whole tree, normalized by the surface area of the root.
+// It's the expected cost of a random ray query and lower is better, but it's only comparable between trees
+// holding the same entities.
+func (t *Tree) SAHCost() float64 {
+	if !t.rootNode.IsValid() {
+		return 0
+	}
+
+	cost := t.nodeSAHCost(t.rootNode)
+
+	if sa := t.rootNode.Box.SurfaceArea(); sa > 0 {
+		cost /= sa
+	}
+
+	return cost
+}
+
+func (t *Tree) nodeSAHCost(n *Node) float64 {
+	if n.IsLeaf() {
+		return n.Box.SurfaceArea() * float64(t.ItemCount(n))
+	}
+	return sahTraversalCost*n.Box.SurfaceArea() + t.nodeSAHCost(n.Left) + t.nodeSAHCost(n.Right)
+}
+
+// Entities returns every entity in the tree, in the order their leaves are found walking down from the root
+func (t *Tree) Entities() []Entity {
+	es := make([]Entity, 0, len(t.leafs))
+	t.Visit(func(BoundingBox) bool { return true }, func(e Entity) bool {
+		es = append(es, e)
+		return true
+	})
+	return es
+}
+
+// Rebuild replaces the hierarchy with a fresh BuildTree of the entities currently in the tree.
+// The tree keeps its options and stays the same *Tree, so references to it remain valid.
+func (t *Tree) Rebuild() {
+	*t = *t.rebuilt()
+}
+
+// referenceDrift is how far the entity count can move away from the one the reference cost was measured with,
+// as a fraction of it, before RebuildIfDegraded measures it again
+const referenceDrift = 0.1
+
+// RebuildIfDegraded rebuilds the tree only if its SAH cost has drifted more than threshold times above
+// that of a fresh build, reporting whether it did.
+// Measuring a fresh build costs as much as a rebuild, O(n log n), so its cost is remembered and only measured
+// again after a rebuild or once the number of entities has changed by more than a tenth. Checks in between only
+// cost the O(n) walk to find the current SAH cost.
+func (t *Tree) RebuildIfDegraded(threshold float64) bool {
+	n := float64(len(t.leafs))
+	if t.referenceCost <= 0 || math.Abs(n-float64(t.referenceEntities)) > n*referenceDrift {
+		fresh := t.rebuilt()
+
+		if t.SAHCost() > fresh.referenceCost*threshold {
+			*t = *fresh
+			return true
+		}
+
+		t.referenceCost, t.referenceEntities = fresh.referenceCost, fresh.referenceEntities
+		return false
+	}
+
+	if t.SAHCost() <= t.referenceCost*threshold {
+		return false
+	}
+
+	t.Rebuild()
+
+	return true
+}
+
+func (t *Tree) rebuilt() *Tree {
+	fresh := newTree(t.Options())
+	fresh.emitBuild(fresh.buildHierarchy(fresh.buildPrims(t.Entities())))
+	fresh.referenceCost, fresh.referenceEntities = fresh.SAHCost(), len(fresh.leafs)
+	return fresh
+}
@@ -0,0 +1,282 @@
+package dyntree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomEntities(count int, spread int) []Entity {
+	rand.Seed(1313131313)
+	es := make([]Entity, count)
+	for i := range es {
+		es[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(spread)), float64(rand.Intn(spread)), float64(rand.Intn(spread))},
+		}
+	}
+	return es
+}
+
+func surfaceAreaSum(n *Node) float64 {
+	if n.IsLeaf() {
+		return n.Box.SurfaceArea()
+	}
+	return n.Box.SurfaceArea() + surfaceAreaSum(n.Left) + surfaceAreaSum(n.Right)
+}
+
+func TestBuildTree(T *testing.T) {
+	es := randomEntities(AMMOUNT, 1000)
+
+	for _, maxLeaves := range []int{1, 4} {
+		built := BuildTree(es, WithMaxLeaves(maxLeaves))
+		checkVolumes(T, built, built.rootNode)
+
+		incremental := NewTree(WithMaxLeaves(maxLeaves))
+		for _, e := range es {
+			incremental.Add(e)
+		}
+
+		if surfaceAreaSum(built.rootNode) > surfaceAreaSum(incremental.rootNode) {
+			T.Fatal("Built tree is worse than adding one at a time", surfaceAreaSum(built.rootNode), surfaceAreaSum(incremental.rootNode))
+		}
+
+		test := func(b BoundingBox) bool {
+			return b.Intersects(BoundingBox{Vec3{100, 100, 100}, Vec3{400, 400, 400}})
+		}
+
+		if len(built.Traverse(test)) != len(incremental.Traverse(test)) {
+			T.Fatal("Built/Incremental disagree")
+		}
+
+		// The built tree has to keep working as a dynamic tree
+		for i, e := range es {
+			switch i % 3 {
+			case 0:
+				built.Remove(e)
+			case 1:
+				e.(*Person).position = e.(*Person).position.Add(Vec3{5, -5, 5})
+				built.QueueForOptimize(e)
+			}
+		}
+		built.Optimize()
+		built.Add(&Person{size: 1})
+
+		checkVolumes(T, built, built.rootNode)
+
+		if got, expected := len(built.Traverse(func(BoundingBox) bool { return true })), AMMOUNT-AMMOUNT/3; got != expected {
+			T.Fatal("Entities lost after updating a built tree", got, expected)
+		}
+	}
+
+	empty := BuildTree(nil)
+	if len(empty.Traverse(func(BoundingBox) bool { return true })) != 0 {
+		T.Fatal("Empty build has entities")
+	}
+	empty.Add(&Person{size: 1})
+
+	same := make([]Entity, 100)
+	for i := range same {
+		same[i] = &Person{size: 1}
+	}
+	stacked := BuildTree(same, WithMaxLeaves(8))
+	checkVolumes(T, stacked, stacked.rootNode)
+}
+
+func BenchmarkTree_BuildSAH(b *testing.B) {
+	es := randomEntities(b.N, 10000)
+
+	b.ResetTimer()
+	BuildTree(es)
+}
+
+func sameHierarchy(a, b *Node) bool {
+	if a.Box != b.Box || a.Depth != b.Depth || a.NodeIndex != b.NodeIndex || a.IsLeaf() != b.IsLeaf() {
+		return false
+	}
+	if a.IsLeaf() {
+		return true
+	}
+	return sameHierarchy(a.Left, b.Left) && sameHierarchy(a.Right, b.Right)
+}
+
+func TestBuildTreeParallel(T *testing.T) {
+	es := randomEntities(50000, 10000)
+
+	serial := BuildTree(es)
+	parallel := BuildTree(es, WithBuildWorkers(4))
+
+	checkVolumes(T, parallel, parallel.rootNode)
+
+	if len(parallel.leafs) != len(es) {
+		T.Fatal("Parallel build lost entities", len(parallel.leafs), len(es))
+	}
+
+	if !sameHierarchy(serial.rootNode, parallel.rootNode) {
+		T.Fatal("Parallel build doesn't match the serial build")
+	}
+
+	if !sameHierarchy(serial.rootNode, BuildTreeParallel(es).rootNode) {
+		T.Fatal("BuildTreeParallel doesn't match the serial build")
+	}
+}
+
+func TestBuildTreeDuplicates(T *testing.T) {
+	a, b := &Person{1, Vec3{0, 0, 0}}, &Person{1, Vec3{10, 0, 0}}
+
+	for _, t := range []*Tree{
+		BuildTree([]Entity{a, b, a}),
+		BuildTreeLinear([]Entity{a, b, a, b}),
+	} {
+		if err := t.Validate(); err != nil {
+			T.Fatal(err)
+		}
+
+		if got := len(t.Entities()); got != 2 {
+			T.Fatal("Repeated entity was added more than once", got)
+		}
+	}
+}
+
+func BenchmarkTree_BuildSAHParallel(b *testing.B) {
+	es := randomEntities(b.N, 10000)
+
+	b.ResetTimer()
+	BuildTreeParallel(es)
+}
+
+func sahCost(t *Tree, n *Node) float64 {
+	if n.IsLeaf() {
+		return n.Box.SurfaceArea() * float64(t.ItemCount(n))
+	}
+	return n.Box.SurfaceArea() + sahCost(t, n.Left) + sahCost(t, n.Right)
+}
+
+func TestBuildTreeLinear(T *testing.T) {
+	es := randomEntities(AMMOUNT, 1000)
+
+	linear := BuildTreeLinear(es)
+	checkVolumes(T, linear, linear.rootNode)
+
+	if len(linear.leafs) != len(es) {
+		T.Fatal("Linear build lost entities", len(linear.leafs), len(es))
+	}
+
+	test := func(b BoundingBox) bool {
+		return b.Intersects(BoundingBox{Vec3{100, 100, 100}, Vec3{400, 400, 400}})
+	}
+
+	expected := 0
+	for _, e := range es {
+		if test(BoxFromEntity(e)) {
+			expected++
+		}
+	}
+
+	if got := len(linear.Traverse(test)); got != expected {
+		T.Fatal("BVH/Loop disagree", got, expected)
+	}
+
+	restructured := BuildTreeLinear(es, WithTreeletPasses(2))
+	checkVolumes(T, restructured, restructured.rootNode)
+
+	if got := len(restructured.Traverse(test)); got != expected {
+		T.Fatal("Restructured BVH/Loop disagree", got, expected)
+	}
+
+	if sahCost(restructured, restructured.rootNode) >= sahCost(linear, linear.rootNode) {
+		T.Fatal("Treelet restructuring didn't improve the tree", sahCost(restructured, restructured.rootNode), sahCost(linear, linear.rootNode))
+	}
+
+	grouped := BuildTreeLinear(es, WithMaxLeaves(4))
+	checkVolumes(T, grouped, grouped.rootNode)
+
+	grouped.Remove(es[0])
+	grouped.Add(es[0])
+	checkVolumes(T, grouped, grouped.rootNode)
+}
+
+func BenchmarkTree_BuildLinear(b *testing.B) {
+	es := randomEntities(b.N, 10000)
+
+	b.ResetTimer()
+	BuildTreeLinear(es)
+}
+
+func BenchmarkTree_BuildLinearTreelets(b *testing.B) {
+	es := randomEntities(b.N, 10000)
+
+	b.ResetTimer()
+	BuildTreeLinear(es, WithTreeletPasses(1))
+}
+
+func TestRebuild(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree(WithMaxLeaves(2))
+
+	live := make(map[Entity]bool)
+	for i := 0; i < 20000; i++ {
+		if len(live) > 10 && rand.Intn(3) == 0 {
+			for e := range live {
+				t.Remove(e)
+				delete(live, e)
+				break
+			}
+			continue
+		}
+
+		p := &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		t.Add(p)
+		live[p] = true
+	}
+
+	if t.RebuildIfDegraded(1000) {
+		T.Fatal("Rebuilt a tree that was within the threshold")
+	}
+
+	// The fresh build was only measured, the next check reuses its cost instead of building again
+	if reference := t.referenceCost; reference <= 0 || t.RebuildIfDegraded(1000) || t.referenceCost != reference {
+		T.Fatal("Reference cost wasn't kept for the next check", reference, t.referenceCost)
+	}
+
+	before := t.SAHCost()
+	ptr := t
+
+	if !t.RebuildIfDegraded(1) {
+		T.Fatal("Churned tree wasn't any worse than a fresh build")
+	}
+
+	if t != ptr || t.maxLeaves != 2 {
+		T.Fatal("Rebuild didn't preserve the tree")
+	}
+
+	if t.SAHCost() >= before {
+		T.Fatal("Rebuild didn't improve the tree", t.SAHCost(), before)
+	}
+
+	checkVolumes(T, t, t.rootNode)
+
+	es := t.Entities()
+	if len(es) != len(live) {
+		T.Fatal("Rebuild lost entities", len(es), len(live))
+	}
+	for _, e := range es {
+		if !live[e] {
+			T.Fatal("Rebuild returned an unknown entity")
+		}
+	}
+
+	t.Rebuild()
+	checkVolumes(T, t, t.rootNode)
+
+	for e := range live {
+		if len(live) <= 10 {
+			break
+		}
+		t.Remove(e)
+		delete(live, e)
+	}
+	checkVolumes(T, t, t.rootNode)
+}
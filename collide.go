@@ -0,0 +1,66 @@
+package dyntree
+
+// OverlappingPairs calls fn once for every pair of entities in the tree whose boxes intersect
+func (t *Tree) OverlappingPairs(fn func(a, b Entity)) {
+	if !t.rootNode.IsValid() {
+		return
+	}
+
+	t.selfPairs(t.rootNode, func(a, b Entity) bool {
+		fn(a, b)
+		return true
+	})
+}
+
+// Intersect calls fn for every pair of intersecting entities where a is from t and b is from other.
+// Returning false from fn stops the walk.
+func (t *Tree) Intersect(other *Tree, fn func(a, b Entity) bool) {
+	if !t.rootNode.IsValid() || !other.rootNode.IsValid() {
+		return
+	}
+
+	crossPairs(t, t.rootNode, other, other.rootNode, fn)
+}
+
+func (t *Tree) selfPairs(n *Node, fn func(a, b Entity) bool) bool {
+	if n.IsLeaf() {
+		b := t.Buckets[n.BucketIndex-1]
+		for i := 0; i < len(b); i++ {
+			box := BoxFromEntity(b[i])
+			for j := i + 1; j < len(b); j++ {
+				if box.Intersects(BoxFromEntity(b[j])) && !fn(b[i], b[j]) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	return t.selfPairs(n.Left, fn) &&
+		t.selfPairs(n.Right, fn) &&
+		crossPairs(t, n.Left, t, n.Right, fn)
+}
+
+// crossPairs reports the overlaps between two disjoint subtrees, always descending into the larger volume first
+func crossPairs(ta *Tree, a *Node, tb *Tree, b *Node, fn func(a, b Entity) bool) bool {
+	if !a.Box.Intersects(b.Box) {
+		return true
+	}
+
+	switch {
+	case a.IsLeaf() && b.IsLeaf():
+		for _, ea := range ta.Buckets[a.BucketIndex-1] {
+			box := BoxFromEntity(ea)
+			for _, eb := range tb.Buckets[b.BucketIndex-1] {
+				if box.Intersects(BoxFromEntity(eb)) && !fn(ea, eb) {
+					return false
+				}
+			}
+		}
+		return true
+	case a.IsLeaf() || !b.IsLeaf() && b.Box.SurfaceArea() > a.Box.SurfaceArea():
+		return crossPairs(ta, a, tb, b.Left, fn) && crossPairs(ta, a, tb, b.Right, fn)
+	default:
+		return crossPairs(ta, a.Left, tb, b, fn) && crossPairs(ta, a.Right, tb, b, fn)
+	}
+}
@@ -0,0 +1,104 @@
+package dyntree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestOverlappingPairs(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree()
+
+	entities := make([]*Person, 2000)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     float64(rand.Intn(10) + 1),
+			position: Vec3{float64(rand.Intn(500)), float64(rand.Intn(500)), float64(rand.Intn(500))},
+		}
+		t.Add(entities[i])
+	}
+
+	expected := 0
+	for i := range entities {
+		for j := i + 1; j < len(entities); j++ {
+			if BoxFromEntity(entities[i]).Intersects(BoxFromEntity(entities[j])) {
+				expected++
+			}
+		}
+	}
+
+	type pair struct{ a, b Entity }
+	seen := make(map[pair]bool)
+
+	t.OverlappingPairs(func(a, b Entity) {
+		if a == b {
+			T.Fatal("Entity paired with itself")
+		}
+		if seen[pair{a, b}] || seen[pair{b, a}] {
+			T.Fatal("Pair reported twice")
+		}
+		seen[pair{a, b}] = true
+	})
+
+	if len(seen) != expected {
+		T.Fatal("BVH/Loop disagree", len(seen), expected)
+	}
+}
+
+func TestIntersect(T *testing.T) {
+	rand.Seed(1313131313)
+	static, dynamic := NewTree(), NewTree()
+
+	walls := make([]*Person, 1000)
+	for i := range walls {
+		walls[i] = &Person{
+			size:     float64(rand.Intn(20) + 1),
+			position: Vec3{float64(rand.Intn(500)), float64(rand.Intn(500)), float64(rand.Intn(500))},
+		}
+		static.Add(walls[i])
+	}
+
+	actors := make([]*Person, 1000)
+	for i := range actors {
+		actors[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(500)), float64(rand.Intn(500)), float64(rand.Intn(500))},
+		}
+		dynamic.Add(actors[i])
+	}
+
+	expected := 0
+	for _, w := range walls {
+		for _, a := range actors {
+			if BoxFromEntity(w).Intersects(BoxFromEntity(a)) {
+				expected++
+			}
+		}
+	}
+
+	found := 0
+	static.Intersect(dynamic, func(a, b Entity) bool {
+		if _, ok := static.GetLeaf(a); !ok {
+			T.Fatal("First entity is not from the receiving tree")
+		}
+		if _, ok := dynamic.GetLeaf(b); !ok {
+			T.Fatal("Second entity is not from the other tree")
+		}
+		found++
+		return true
+	})
+
+	if found != expected {
+		T.Fatal("BVH/Loop disagree", found, expected)
+	}
+
+	calls := 0
+	static.Intersect(dynamic, func(a, b Entity) bool {
+		calls++
+		return false
+	})
+
+	if expected > 0 && calls != 1 {
+		T.Fatal("Walk did not stop early, callback ran", calls, "times")
+	}
+}
@@ -0,0 +1,105 @@
+package dyntree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DOTOptions limits how much of the tree WriteDOT draws
+type DOTOptions struct {
+	// Root is the subtree to draw, the whole tree when nil
+	Root *Node
+	// MaxDepth is how many levels below Root are drawn, or every level when 0.
+	// Branches at the cutoff are drawn dashed to show there's more below them.
+	MaxDepth int
+}
+
+// Root returns the root node of the tree, for passing to the functions that work on a subtree
+func (t *Tree) Root() *Node {
+	return t.rootNode
+}
+
+// WriteDOT writes the hierarchy as a Graphviz graph, labelling each node with its depth, surface area and
+// bucket size. Nodes are shaded from green to red by the SAH cost of their subtree relative to their own surface
+// area, which makes long chains of poorly split branches stand out.
+func (t *Tree) WriteDOT(w io.Writer, opts DOTOptions) error {
+	root := opts.Root
+	if root == nil {
+		root = t.rootNode
+	}
+
+	costs := make(map[*Node]float64)
+	t.relativeSAHCost(root, costs)
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, c := range costs {
+		lo = math.Min(lo, c)
+		hi = math.Max(hi, c)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph dyntree {")
+	fmt.Fprintln(bw, "\tnode [shape=box, style=filled, fontname=monospace];")
+
+	stack := []*Node{root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		level := n.Depth - root.Depth
+		cut := opts.MaxDepth > 0 && level >= opts.MaxDepth
+
+		label := fmt.Sprintf("#%d\\ndepth %d\\nSA %.4g\\nSAH %.4g", n.NodeIndex, n.Depth, n.Box.SurfaceArea(), costs[n])
+		style := "filled"
+
+		if n.IsLeaf() {
+			label += fmt.Sprintf("\\nbucket %d", t.ItemCount(n))
+		} else if cut {
+			style = "filled,dashed"
+		}
+
+		fmt.Fprintf(bw, "\tn%d [label=\"%s\", style=\"%s\", fillcolor=\"%s\"];\n", n.NodeIndex, label, style, costColor(costs[n], lo, hi))
+
+		if n.IsLeaf() || cut || !n.IsValidBranchNode() {
+			continue
+		}
+
+		fmt.Fprintf(bw, "\tn%d -> n%d;\n", n.NodeIndex, n.Left.NodeIndex)
+		fmt.Fprintf(bw, "\tn%d -> n%d;\n", n.NodeIndex, n.Right.NodeIndex)
+
+		stack = append(stack, n.Right, n.Left)
+	}
+
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// relativeSAHCost fills costs with the SAH cost of every subtree below n divided by the surface area of its root,
+// the expected number of entity tests and node visits for a query that's known to hit it
+func (t *Tree) relativeSAHCost(n *Node, costs map[*Node]float64) (cost float64) {
+	if n.IsLeaf() || !n.IsValidBranchNode() {
+		cost = n.Box.SurfaceArea() * float64(t.ItemCount(n))
+	} else {
+		cost = sahTraversalCost*n.Box.SurfaceArea() + t.relativeSAHCost(n.Left, costs) + t.relativeSAHCost(n.Right, costs)
+	}
+
+	if sa := n.Box.SurfaceArea(); sa > 0 {
+		costs[n] = cost / sa
+	}
+
+	return
+}
+
+// costColor shades from green at lo to red at hi, on a log scale since costs grow roughly with subtree height
+func costColor(c, lo, hi float64) string {
+	f := 0.0
+	if hi > lo && lo > 0 {
+		f = math.Log(c/lo) / math.Log(hi/lo)
+	}
+	f = math.Max(0, math.Min(f, 1))
+
+	return fmt.Sprintf("#%02x%02x80", int(128+127*f), int(128+127*(1-f)))
+}
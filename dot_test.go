@@ -0,0 +1,63 @@
+package dyntree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteDOT(T *testing.T) {
+	t := BuildTree(randomEntities(200, 200))
+	s := t.Stats()
+
+	buf := bytes.Buffer{}
+	if err := t.WriteDOT(&buf, DOTOptions{}); err != nil {
+		T.Fatal(err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "digraph") {
+		T.Fatal("Not a graph", out[:20])
+	}
+	if ct := strings.Count(out, "[label="); ct != s.Nodes {
+		T.Fatal("Expected every node to be drawn", ct, s.Nodes)
+	}
+	if ct := strings.Count(out, " -> "); ct != s.Nodes-1 {
+		T.Fatal("Expected an edge to every node but the root", ct, s.Nodes-1)
+	}
+
+	buf.Reset()
+	if err := t.WriteDOT(&buf, DOTOptions{MaxDepth: 2}); err != nil {
+		T.Fatal(err)
+	}
+	if ct := strings.Count(buf.String(), "[label="); ct != 7 {
+		T.Fatal("Expected three levels of nodes", ct)
+	}
+	if !strings.Contains(buf.String(), "dashed") {
+		T.Fatal("Cut off branches aren't marked")
+	}
+
+	sub := t.Root().Left
+	buf.Reset()
+	if err := t.WriteDOT(&buf, DOTOptions{Root: sub}); err != nil {
+		T.Fatal(err)
+	}
+	if strings.Contains(buf.String(), fmt.Sprintf("\tn%d ", t.Root().NodeIndex)) {
+		T.Fatal("Subtree output includes the root")
+	}
+	if !strings.Contains(buf.String(), fmt.Sprintf("\tn%d [", sub.NodeIndex)) {
+		T.Fatal("Subtree output is missing its own root")
+	}
+
+	if err := t.WriteDOT(failingWriter{}, DOTOptions{}); err == nil {
+		T.Fatal("Write error wasn't returned")
+	}
+}
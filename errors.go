@@ -0,0 +1,39 @@
+package dyntree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrEntityNotFound is returned when removing an entity the tree doesn't hold
+	ErrEntityNotFound = errors.New("dyntree: entity not found")
+	// ErrDuplicateEntity is returned when adding an entity the tree already holds
+	ErrDuplicateEntity = errors.New("dyntree: entity already in tree")
+	// ErrCorruptTree is returned when the tree's internal structure is found to be inconsistent
+	ErrCorruptTree = errors.New("dyntree: corrupt tree")
+)
+
+// corruption is what the tree's internal consistency checks panic with, matching ErrCorruptTree
+type corruption string
+
+func (c corruption) Error() string {
+	return fmt.Sprintf("%v: %s", ErrCorruptTree, string(c))
+}
+
+func (c corruption) Unwrap() error {
+	return ErrCorruptTree
+}
+
+// recoverCorrupt turns a panic from one of the tree's internal consistency checks into an ErrCorruptTree,
+// for use as a deferred call in functions returning an error. Any other panic, like a runtime error or one
+// from an entity's own methods, isn't ours to swallow and carries on unwinding.
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		c, ok := r.(corruption)
+		if !ok {
+			panic(r)
+		}
+		*err = c
+	}
+}
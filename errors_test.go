@@ -0,0 +1,98 @@
+package dyntree
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestTryAddRemove(T *testing.T) {
+	t := NewTree()
+	a := &Person{1, Vec3{0, 0, 0}}
+	b := &Person{1, Vec3{10, 0, 0}}
+
+	if err := t.TryAdd(a); err != nil {
+		T.Fatal("Unexpected error adding", err)
+	}
+	if err := t.TryAdd(a); !errors.Is(err, ErrDuplicateEntity) {
+		T.Fatal("Expected ErrDuplicateEntity, got", err)
+	}
+	if err := t.TryRemove(b); !errors.Is(err, ErrEntityNotFound) {
+		T.Fatal("Expected ErrEntityNotFound, got", err)
+	}
+
+	t.Add(b)
+
+	// Removing down to nothing has to leave an empty tree that can be added to again, not a panic
+	for _, e := range []Entity{a, b} {
+		if err := t.TryRemove(e); err != nil {
+			T.Fatal("Unexpected error removing", err)
+		}
+		if err := t.Validate(); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	if len(t.Traverse(func(BoundingBox) bool { return true })) != 0 {
+		T.Fatal("Tree isn't empty after removing everything")
+	}
+
+	t.Add(a)
+	if hit, ok := t.Nearest(Vec3{}); !ok || hit != a {
+		T.Fatal("Tree unusable after being emptied")
+	}
+
+	// An entity mapped to a leaf that doesn't hold it is corruption, not a missing entity
+	t.MapLeaf(b, t.rootNode)
+	if err := t.TryRemove(b); !errors.Is(err, ErrCorruptTree) {
+		T.Fatal("Expected ErrCorruptTree, got", err)
+	}
+
+	if err := t.Validate(); !errors.Is(err, ErrCorruptTree) {
+		T.Fatal("Validation errors should match ErrCorruptTree, got", err)
+	}
+}
+
+type brokenPerson struct {
+	Person
+	broken *Vec3
+}
+
+func (p *brokenPerson) Position() Vec3 {
+	return *p.broken
+}
+
+func TestTryAddForeignPanic(T *testing.T) {
+	t := NewTree()
+	t.Add(&Person{1, Vec3{0, 0, 0}})
+
+	// A panic from the entity itself is the caller's bug and has to reach them rather than pass for corruption
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			T.Fatal("Runtime error from an entity was swallowed")
+		}
+	}()
+	t.TryAdd(&brokenPerson{})
+}
+
+func TestTryQueueForOptimizeCorrupt(T *testing.T) {
+	p := &Person{1, Vec3{0, 0, 0}}
+	t := BuildTree([]Entity{p, &Person{1, Vec3{10, 0, 0}}, &Person{1, Vec3{20, 0, 0}}, &Person{1, Vec3{30, 0, 0}}})
+
+	// Break the half of the tree p isn't in, which is still on the way down when looking for a better node
+	top, _ := t.GetLeaf(p)
+	for top.Parent != t.rootNode {
+		top = top.Parent
+	}
+	other := top.GetSibling()
+	other.Left, other.Right, other.BucketIndex = nil, nil, -1
+
+	p.position = Vec3{5, 0, 0}
+
+	if _, err := t.TryQueueForOptimize(p); !errors.Is(err, ErrCorruptTree) {
+		T.Fatal("Expected ErrCorruptTree from TryQueueForOptimize, got", err)
+	}
+	if _, err := t.TryUpdate([]Entity{p}); !errors.Is(err, ErrCorruptTree) {
+		T.Fatal("Expected ErrCorruptTree from TryUpdate, got", err)
+	}
+}
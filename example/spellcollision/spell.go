@@ -43,20 +43,23 @@ func (l *LingeringAoESpell) HitTest(b dyntree.BoundingBox) bool {
 	return b.Intersects(dyntree.BoxFromEntity(l))
 }
 
-func (l *LingeringAoESpell) DrawImage(i *image.RGBA) {
-	x, y, dx, dy := int(l.radius)-1, 0, 1, 1
-	err := dx - (int(l.radius) * 2)
+func (l *LingeringAoESpell) DrawImage(i *image.RGBA, tf dyntree.Transform) {
+	center := tf.Point(l.position)
+	radius := int(tf.Length(l.radius))
+
+	x, y, dx, dy := radius-1, 0, 1, 1
+	err := dx - (radius * 2)
 
 	c := color.RGBA{255, 255, 0, 255}
 	for x > y {
-		i.Set(int(l.position.X)+x, int(l.position.Y)+y, c)
-		i.Set(int(l.position.X)+y, int(l.position.Y)+x, c)
-		i.Set(int(l.position.X)-y, int(l.position.Y)+x, c)
-		i.Set(int(l.position.X)-x, int(l.position.Y)+y, c)
-		i.Set(int(l.position.X)-x, int(l.position.Y)-y, c)
-		i.Set(int(l.position.X)-y, int(l.position.Y)-x, c)
-		i.Set(int(l.position.X)+y, int(l.position.Y)-x, c)
-		i.Set(int(l.position.X)+x, int(l.position.Y)-y, c)
+		i.Set(center.X+x, center.Y+y, c)
+		i.Set(center.X+y, center.Y+x, c)
+		i.Set(center.X-y, center.Y+x, c)
+		i.Set(center.X-x, center.Y+y, c)
+		i.Set(center.X-x, center.Y-y, c)
+		i.Set(center.X-y, center.Y-x, c)
+		i.Set(center.X+y, center.Y-x, c)
+		i.Set(center.X+x, center.Y-y, c)
 
 		if err <= 0 {
 			y++
@@ -66,7 +69,7 @@ func (l *LingeringAoESpell) DrawImage(i *image.RGBA) {
 		if err > 0 {
 			x--
 			dx += 2
-			err += dx - (int(l.radius) * 2)
+			err += dx - (radius * 2)
 		}
 	}
 }
@@ -176,5 +179,7 @@ func main() {
 	fmt.Println("Dumping image of tree at ./spell.bmp")
 	// Add our spell so it will get it's DrawImage function called when we're saving an image of the tree
 	tree.Add(spell)
-	tree.Image("./spell.bmp")
+	if err := tree.Image("./spell.bmp"); err != nil {
+		fmt.Println("Failed to save image:", err)
+	}
 }
@@ -0,0 +1,245 @@
+package dyntree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type jsonTree struct {
+	Root    int         `json:"root"`
+	Options jsonOptions `json:"options"`
+	Nodes   []jsonNode  `json:"nodes"`
+	Free    []int       `json:"free,omitempty"`
+}
+
+type jsonOptions struct {
+	MaxLeaves         int     `json:"maxLeaves"`
+	Margin            float64 `json:"margin"`
+	VelocityHorizon   float64 `json:"velocityHorizon"`
+	PushdownFactor    float64 `json:"pushdownFactor"`
+	RotationThreshold float64 `json:"rotationThreshold"`
+	BuildWorkers      int     `json:"buildWorkers"`
+	TreeletPasses     int     `json:"treeletPasses"`
+}
+
+type jsonNode struct {
+	Index    int          `json:"index"`
+	Depth    int          `json:"depth"`
+	Box      jsonBox      `json:"box"`
+	Parent   *int         `json:"parent,omitempty"`
+	Left     *int         `json:"left,omitempty"`
+	Right    *int         `json:"right,omitempty"`
+	Entities []JSONEntity `json:"entities,omitempty"`
+}
+
+type jsonBox struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
+}
+
+// JSONEntity is how ExportJSON writes an entity, and what ImportJSON stands in for entities with when it isn't
+// given a way to resolve their IDs
+type JSONEntity struct {
+	ID   string     `json:"id"`
+	Pos  [3]float64 `json:"position"`
+	Size float64    `json:"radius"`
+}
+
+func (e *JSONEntity) Position() Vec3 {
+	return Vec3{e.Pos[0], e.Pos[1], e.Pos[2]}
+}
+
+func (e *JSONEntity) Radius() float64 {
+	return e.Size
+}
+
+func (e *JSONEntity) String() string {
+	return e.ID
+}
+
+func toJSONVec(v Vec3) [3]float64 {
+	return [3]float64{v.X, v.Y, v.Z}
+}
+
+func fromJSONVec(v [3]float64) Vec3 {
+	return Vec3{v[0], v[1], v[2]}
+}
+
+func nodeRef(n *Node) *int {
+	if n == nil {
+		return nil
+	}
+	i := n.NodeIndex
+	return &i
+}
+
+// ExportJSON writes the topology of the tree to w for inspection in other tools, listing every node in the
+// tree by index along with its box, links and the entities in its bucket, followed by the free node indices.
+// Entities are identified by how fmt
+// prints them, so implementing fmt.Stringer gives them a readable ID.
+func (t *Tree) ExportJSON(w io.Writer) error {
+	o := t.Options()
+	out := jsonTree{
+		Root: t.rootNode.NodeIndex,
+		Options: jsonOptions{
+			MaxLeaves:         o.MaxLeaves,
+			Margin:            o.Margin,
+			VelocityHorizon:   o.VelocityHorizon,
+			PushdownFactor:    o.PushdownFactor,
+			RotationThreshold: o.RotationThreshold,
+			BuildWorkers:      o.BuildWorkers,
+			TreeletPasses:     o.TreeletPasses,
+		},
+		Nodes: make([]jsonNode, 0),
+		Free:  append([]int{}, t.unusedNodeIndicies...),
+	}
+
+	stack := []*Node{t.rootNode}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		jn := jsonNode{
+			Index:  n.NodeIndex,
+			Depth:  n.Depth,
+			Box:    jsonBox{toJSONVec(n.Box.Min), toJSONVec(n.Box.Max)},
+			Parent: nodeRef(n.Parent),
+		}
+
+		if n.IsLeaf() {
+			for _, e := range t.Buckets[n.BucketIndex-1] {
+				jn.Entities = append(jn.Entities, JSONEntity{fmt.Sprint(e), toJSONVec(e.Position()), e.Radius()})
+			}
+		} else if n.IsValidBranchNode() {
+			jn.Left = nodeRef(n.Left)
+			jn.Right = nodeRef(n.Right)
+			stack = append(stack, n.Right, n.Left)
+		}
+
+		out.Nodes = append(out.Nodes, jn)
+	}
+
+	sort.Slice(out.Nodes, func(i, j int) bool { return out.Nodes[i].Index < out.Nodes[j].Index })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(out)
+}
+
+// ImportJSON recreates a tree written by ExportJSON with the same node indices and topology, so exporting it
+// again gives the same JSON. Entity IDs are turned back into entities by resolve, or become *JSONEntity stubs
+// if it's nil.
+func ImportJSON(r io.Reader, resolve func(id string) (Entity, error)) (*Tree, error) {
+	var in jsonTree
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, fmt.Errorf("dyntree: decoding tree: %w", err)
+	}
+
+	t, err := NewTreeWithOptions(TreeOptions{
+		MaxLeaves:         in.Options.MaxLeaves,
+		Margin:            in.Options.Margin,
+		VelocityHorizon:   in.Options.VelocityHorizon,
+		PushdownFactor:    in.Options.PushdownFactor,
+		RotationThreshold: in.Options.RotationThreshold,
+		BuildWorkers:      in.Options.BuildWorkers,
+		TreeletPasses:     in.Options.TreeletPasses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every index is either a node or free, so the input can't make us allocate more than it lists
+	count := len(in.Nodes) + len(in.Free)
+	t.nodes = make([]*Node, count)
+	t.Buckets = make([][]Entity, 0)
+	t.unusedNodeIndicies = make([]int, 0, len(in.Free))
+
+	claim := func(i int) error {
+		if i < 0 || i >= count {
+			return fmt.Errorf("dyntree: node index %d out of range", i)
+		}
+		if t.nodes[i] != nil {
+			return fmt.Errorf("dyntree: node %d listed twice", i)
+		}
+		t.nodes[i] = &Node{NodeIndex: i, BucketIndex: -1}
+		return nil
+	}
+
+	for _, jn := range in.Nodes {
+		if err := claim(jn.Index); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range in.Free {
+		if err := claim(i); err != nil {
+			return nil, err
+		}
+		t.unusedNodeIndicies = append(t.unusedNodeIndicies, i)
+	}
+
+	link := func(ref *int) (*Node, error) {
+		if ref == nil {
+			return nil, nil
+		}
+		if *ref < 0 || *ref >= count {
+			return nil, fmt.Errorf("dyntree: node index %d out of range", *ref)
+		}
+		return t.nodes[*ref], nil
+	}
+
+	for _, jn := range in.Nodes {
+		n := t.nodes[jn.Index]
+		n.Depth = jn.Depth
+		n.Box = BoundingBox{fromJSONVec(jn.Box.Min), fromJSONVec(jn.Box.Max)}
+
+		if n.Parent, err = link(jn.Parent); err != nil {
+			return nil, err
+		}
+		if n.Left, err = link(jn.Left); err != nil {
+			return nil, err
+		}
+		if n.Right, err = link(jn.Right); err != nil {
+			return nil, err
+		}
+
+		if n.Depth > t.maxDepth {
+			t.maxDepth = n.Depth
+		}
+
+		if n.Left != nil || n.Right != nil {
+			continue
+		}
+
+		bucket := make([]Entity, len(jn.Entities))
+		for i := range jn.Entities {
+			je := jn.Entities[i]
+			if resolve == nil {
+				bucket[i] = &je
+			} else if bucket[i], err = resolve(je.ID); err != nil {
+				return nil, fmt.Errorf("dyntree: resolving entity %q: %w", je.ID, err)
+			} else if bucket[i] == nil {
+				return nil, fmt.Errorf("dyntree: entity %q resolved to nil", je.ID)
+			}
+			t.MapLeaf(bucket[i], n)
+		}
+
+		t.Buckets = append(t.Buckets, bucket)
+		n.BucketIndex = len(t.Buckets)
+	}
+
+	root, err := link(&in.Root)
+	if err != nil {
+		return nil, err
+	}
+	t.rootNode = root
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("dyntree: importing tree: %w", err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,88 @@
+package dyntree
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type namedPerson struct {
+	Person
+	name string
+}
+
+func (p *namedPerson) String() string {
+	return p.name
+}
+
+func TestExportJSONRoundTrip(T *testing.T) {
+	es := randomEntities(500, 500)
+	byID := make(map[string]Entity, len(es))
+	for i, e := range es {
+		named := &namedPerson{*e.(*Person), fmt.Sprint("person-", i)}
+		es[i] = named
+		byID[named.name] = named
+	}
+
+	t := NewTree(WithMaxLeaves(3))
+	for _, e := range es {
+		t.Add(e)
+	}
+	for _, e := range es[:100] {
+		t.Remove(e)
+		delete(byID, e.(*namedPerson).name)
+	}
+
+	golden := bytes.Buffer{}
+	if err := t.ExportJSON(&golden); err != nil {
+		T.Fatal(err)
+	}
+	if !strings.Contains(golden.String(), `"id": "person-100"`) {
+		T.Fatal("Entities aren't identified by their String method")
+	}
+
+	// Without a resolver the entities are stubs, which still have to export exactly as the originals did
+	stubbed, err := ImportJSON(bytes.NewReader(golden.Bytes()), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	again := bytes.Buffer{}
+	if err := stubbed.ExportJSON(&again); err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(golden.Bytes(), again.Bytes()) {
+		T.Fatal("Re-exported JSON doesn't match the original")
+	}
+
+	resolved, err := ImportJSON(bytes.NewReader(golden.Bytes()), func(id string) (Entity, error) {
+		if e, ok := byID[id]; ok {
+			return e, nil
+		}
+		return nil, ErrEntityNotFound
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Free buckets aren't exported, but everything a query can see is the same
+	sameQueries(T, t, resolved)
+
+	if _, err := ImportJSON(bytes.NewReader(golden.Bytes()), func(string) (Entity, error) { return nil, nil }); err == nil {
+		T.Fatal("Imported a tree with nil entities")
+	}
+
+	for _, bad := range []string{
+		`{"root": 3, "nodes": [{"index": 0}]}`,
+		`{"root": 0, "nodes": [{"index": 9000000000000000000}]}`,
+		`{"root": 0, "nodes": [{"index": 100000000}]}`,
+		`{"root": 0, "nodes": [{"index": -1}]}`,
+		`{"root": 0, "nodes": [{"index": 0}, {"index": 0}]}`,
+		`{"root": 0, "nodes": [{"index": 0}], "free": [0]}`,
+	} {
+		if _, err := ImportJSON(strings.NewReader(bad), nil); err == nil {
+			T.Fatal("Imported a malformed tree", bad)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package dyntree
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// mortonBits is the resolution of each axis in a morton code, three of them fit in a uint64
+	mortonBits = 21
+	// treeletSize is how many subtrees a treelet is grown to before its topology is optimized.
+	// The optimization is exhaustive, so each extra one roughly triples the cost of a pass.
+	treeletSize = 7
+)
+
+type mortonKey struct {
+	code  uint64
+	index int
+}
+
+// BuildTreeLinear creates a tree for very large sets of entities by sorting them along a 3D Morton curve of their
+// positions and emitting the hierarchy in a single pass over the sorted codes. It's much faster than BuildTree but
+// gives a worse tree, which WithTreeletPasses can win most of the way back.
+func BuildTreeLinear(entities []Entity, opts ...Option) *Tree {
+	t := NewTree(opts...)
+
+	prims, codes := t.mortonSort(t.buildPrims(entities))
+	root := t.buildLinear(prims, codes)
+
+	for i := 0; i < t.treeletPasses; i++ {
+		restructureTreelets(root)
+	}
+
+	t.emitBuild(root)
+
+	return t
+}
+
+// mortonSort returns prims ordered by the morton code of their entity's position, along with the sorted codes
+func (t *Tree) mortonSort(prims []buildPrim) ([]buildPrim, []uint64) {
+	if len(prims) == 0 {
+		return prims, nil
+	}
+
+	positions := make([]Vec3, len(prims))
+	bounds := BoundingBox{prims[0].entity.Position(), prims[0].entity.Position()}
+	for i, p := range prims {
+		positions[i] = p.entity.Position()
+		bounds = bounds.Expand(BoundingBox{positions[i], positions[i]})
+	}
+
+	keys := make([]mortonKey, len(prims))
+	for i, p := range positions {
+		keys[i] = mortonKey{mortonCode(p, bounds), i}
+	}
+
+	// LSD radix sort a byte at a time, skipping any byte every code agrees on
+	tmp := make([]mortonKey, len(keys))
+	for shift := uint(0); shift < 64; shift += 8 {
+		var counts [257]int
+		for _, k := range keys {
+			counts[(k.code>>shift)&0xff+1]++
+		}
+
+		if counts[(keys[0].code>>shift)&0xff+1] == len(keys) {
+			continue
+		}
+
+		for i := 1; i < len(counts); i++ {
+			counts[i] += counts[i-1]
+		}
+
+		for _, k := range keys {
+			d := (k.code >> shift) & 0xff
+			tmp[counts[d]] = k
+			counts[d]++
+		}
+
+		keys, tmp = tmp, keys
+	}
+
+	sorted := make([]buildPrim, len(prims))
+	codes := make([]uint64, len(prims))
+	for i, k := range keys {
+		sorted[i] = prims[k.index]
+		codes[i] = k.code
+	}
+
+	return sorted, codes
+}
+
+func mortonCode(p Vec3, bounds BoundingBox) uint64 {
+	return spreadBits(quantize(p.X, bounds.Min.X, bounds.Max.X))<<2 |
+		spreadBits(quantize(p.Y, bounds.Min.Y, bounds.Max.Y))<<1 |
+		spreadBits(quantize(p.Z, bounds.Min.Z, bounds.Max.Z))
+}
+
+func quantize(v, min, max float64) uint64 {
+	if max <= min {
+		return 0
+	}
+
+	q := (v - min) / (max - min) * (1<<mortonBits - 1)
+	return uint64(math.Max(0, math.Min(q, 1<<mortonBits-1)))
+}
+
+// spreadBits moves the lowest 21 bits of v so there are two zero bits between each of them
+func spreadBits(v uint64) uint64 {
+	v &= 1<<mortonBits - 1
+	v = (v | v<<32) & 0x1f00000000ffff
+	v = (v | v<<16) & 0x1f0000ff0000ff
+	v = (v | v<<8) & 0x100f00f00f00f00f
+	v = (v | v<<4) & 0x10c30c30c30c30c3
+	v = (v | v<<2) & 0x1249249249249249
+	return v
+}
+
+func (t *Tree) buildLinear(prims []buildPrim, codes []uint64) *buildNode {
+	if len(prims) <= t.maxLeaves || len(prims) <= 1 {
+		n := &buildNode{prims: prims}
+		for i, p := range prims {
+			if i == 0 {
+				n.box = p.box
+			} else {
+				n.box = n.box.Expand(p.box)
+			}
+		}
+		return n
+	}
+
+	mid := mortonSplit(codes)
+
+	n := &buildNode{
+		left:  t.buildLinear(prims[:mid], codes[:mid]),
+		right: t.buildLinear(prims[mid:], codes[mid:]),
+	}
+	n.box = n.left.box.Expand(n.right.box)
+
+	return n
+}
+
+// mortonSplit returns where the highest bit that differs across the sorted codes flips from 0 to 1
+func mortonSplit(codes []uint64) int {
+	first, last := codes[0], codes[len(codes)-1]
+	if first == last {
+		return len(codes) / 2
+	}
+
+	mask := uint64(1) << uint(63-bits.LeadingZeros64(first^last))
+
+	lo, hi := 0, len(codes)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if codes[mid]&mask != 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return lo
+}
+
+// restructureTreelets walks the hierarchy bottom up, replacing the topology of the treelet rooted at each branch
+// with the one that minimizes its SAH cost. It returns the cost of n.
+func restructureTreelets(n *buildNode) float64 {
+	if n.IsLeaf() {
+		n.cost = n.box.SurfaceArea() * float64(len(n.prims))
+		return n.cost
+	}
+
+	cost := sahTraversalCost*n.box.SurfaceArea() + restructureTreelets(n.left) + restructureTreelets(n.right)
+	n.cost = optimizeTreelet(n, cost)
+
+	return n.cost
+}
+
+func optimizeTreelet(root *buildNode, rootCost float64) float64 {
+	// Grow the treelet by repeatedly opening up whichever of its subtrees has the largest surface area
+	leaves := []*buildNode{root.left, root.right}
+	internals := []*buildNode{root}
+
+	for len(leaves) < treeletSize {
+		best := -1
+		for i, l := range leaves {
+			if !l.IsLeaf() && (best == -1 || l.box.SurfaceArea() > leaves[best].box.SurfaceArea()) {
+				best = i
+			}
+		}
+
+		if best == -1 {
+			break
+		}
+
+		l := leaves[best]
+		internals = append(internals, l)
+		leaves[best] = l.left
+		leaves = append(leaves, l.right)
+	}
+
+	// Two subtrees can only be arranged one way
+	if len(leaves) < 3 {
+		return rootCost
+	}
+
+	full := 1<<uint(len(leaves)) - 1
+	area := make([]float64, full+1)
+	cost := make([]float64, full+1)
+	split := make([]int, full+1)
+
+	for s := 1; s <= full; s++ {
+		var box BoundingBox
+		first := true
+		for i, l := range leaves {
+			if s&(1<<uint(i)) == 0 {
+				continue
+			}
+			if first {
+				box, first = l.box, false
+			} else {
+				box = box.Expand(l.box)
+			}
+		}
+		area[s] = box.SurfaceArea()
+	}
+
+	for i, l := range leaves {
+		cost[1<<uint(i)] = l.cost
+	}
+
+	// Every proper subset of s is numerically smaller than s, so they've all been costed by the time we get to s
+	for s := 1; s <= full; s++ {
+		if s&(s-1) == 0 {
+			continue
+		}
+
+		best := math.Inf(1)
+		low := s & -s
+		for p := (s - 1) & s; p > 0; p = (p - 1) & s {
+			// Only consider the half holding the lowest subtree so each partition is priced once
+			if p&low == 0 {
+				continue
+			}
+			if c := cost[p] + cost[s^p]; c < best {
+				best = c
+				split[s] = p
+			}
+		}
+
+		cost[s] = sahTraversalCost*area[s] + best
+	}
+
+	if cost[full] >= rootCost*(1-1e-9) {
+		return rootCost
+	}
+
+	pool := internals[1:]
+
+	var emit func(s int) *buildNode
+	emit = func(s int) *buildNode {
+		if s&(s-1) == 0 {
+			return leaves[bits.TrailingZeros(uint(s))]
+		}
+
+		n := root
+		if s != full {
+			n, pool = pool[0], pool[1:]
+		}
+
+		n.left = emit(split[s])
+		n.right = emit(s ^ split[s])
+		n.box = n.left.box.Expand(n.right.box)
+		n.cost = cost[s]
+
+		return n
+	}
+	emit(full)
+
+	return cost[full]
+}
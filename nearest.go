@@ -0,0 +1,83 @@
+package dyntree
+
+import (
+	"container/heap"
+	"math"
+)
+
+type nearestItem struct {
+	node   *Node
+	entity Entity
+	dist   float64
+}
+
+type nearestQueue []nearestItem
+
+func (q nearestQueue) Len() int            { return len(q) }
+func (q nearestQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q nearestQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nearestQueue) Push(x interface{}) { *q = append(*q, x.(nearestItem)) }
+func (q *nearestQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// EntityDistance returns how far p is from the surface of the sphere described by e, or 0 if p is inside of it
+func EntityDistance(p Vec3, e Entity) float64 {
+	return math.Max(0, e.Position().Sub(p).Length()-e.Radius())
+}
+
+// Nearest returns the entity whose sphere is closest to p
+func (t *Tree) Nearest(p Vec3) (Entity, bool) {
+	es := t.KNearest(p, 1)
+	if len(es) == 0 {
+		return nil, false
+	}
+	return es[0], true
+}
+
+// KNearest returns up to k entities ordered by the distance from p to their spheres, nearest first
+func (t *Tree) KNearest(p Vec3, k int) []Entity {
+	if k <= 0 || !t.rootNode.IsValid() {
+		return nil
+	}
+
+	// k is allowed to be far larger than the tree, so it can't size the result on its own
+	size := k
+	if size > len(t.leafs) {
+		size = len(t.leafs)
+	}
+
+	found := make([]Entity, 0, size)
+	q := &nearestQueue{{node: t.rootNode, dist: t.rootNode.Box.Distance(p)}}
+
+	// A node's box always contains the spheres below it, so the distance to the box is a lower bound
+	// for anything inside of it and the first k entities we pop are the k nearest.
+	for q.Len() > 0 && len(found) < k {
+		item := heap.Pop(q).(nearestItem)
+
+		if item.entity != nil {
+			found = append(found, item.entity)
+			continue
+		}
+
+		n := item.node
+		if !n.IsValid() {
+			continue
+		}
+
+		if n.IsLeaf() {
+			for _, e := range t.Buckets[n.BucketIndex-1] {
+				heap.Push(q, nearestItem{entity: e, dist: EntityDistance(p, e)})
+			}
+			continue
+		}
+
+		heap.Push(q, nearestItem{node: n.Left, dist: n.Left.Box.Distance(p)})
+		heap.Push(q, nearestItem{node: n.Right, dist: n.Right.Box.Distance(p)})
+	}
+
+	return found
+}
@@ -0,0 +1,54 @@
+package dyntree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestKNearest(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree()
+
+	entities := make([]Entity, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     float64(rand.Intn(5) + 1),
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		t.Add(entities[i])
+	}
+
+	p := Vec3{500, 500, 500}
+
+	sort.SliceStable(entities, func(i, j int) bool { return EntityDistance(p, entities[i]) < EntityDistance(p, entities[j]) })
+
+	found := t.KNearest(p, 10)
+	if len(found) != 10 {
+		T.Fatal("Expected 10 entities, got", len(found))
+	}
+
+	for i, e := range found {
+		if EntityDistance(p, e) != EntityDistance(p, entities[i]) {
+			T.Fatal("BVH/Loop disagree at", i, EntityDistance(p, e), EntityDistance(p, entities[i]))
+		}
+	}
+
+	nearest, ok := t.Nearest(p)
+	if !ok || EntityDistance(p, nearest) != EntityDistance(p, entities[0]) {
+		T.Fatal("Nearest did not return the closest entity")
+	}
+
+	if len(t.KNearest(p, AMMOUNT*2)) != AMMOUNT {
+		T.Fatal("KNearest should return every entity when k exceeds the entity count")
+	}
+
+	if len(t.KNearest(p, math.MaxInt64)) != AMMOUNT {
+		T.Fatal("KNearest should return every entity when k is huge")
+	}
+
+	if _, ok := NewTree().Nearest(p); ok {
+		T.Fatal("Nearest on an empty tree found something")
+	}
+}
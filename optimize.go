@@ -0,0 +1,181 @@
+package dyntree
+
+import (
+	"container/heap"
+	"time"
+)
+
+type rotationItem struct {
+	node *Node
+	gain float64
+}
+
+type rotationQueue []rotationItem
+
+func (q rotationQueue) Len() int            { return len(q) }
+func (q rotationQueue) Less(i, j int) bool  { return q[i].gain > q[j].gain }
+func (q rotationQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *rotationQueue) Push(x interface{}) { *q = append(*q, x.(rotationItem)) }
+func (q *rotationQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// optimizeChunk is how many branches OptimizeFor refits and prices between looking at the clock
+const optimizeChunk = 64
+
+// OptimizeFor spreads the work of Optimize over as many calls as it takes, spending roughly budget on each.
+// Leaves queued by QueueForOptimize are always refit straight away so queries stay correct. Their ancestors are
+// then refit and priced, and the rotations applied the ones saving the most surface area first, until the budget
+// runs out. At least a chunk of branches or one rotation is looked at per call so a tiny budget still makes progress.
+// It returns how many nodes are still waiting to be looked at, 0 once the tree is fully optimized.
+func (t *Tree) OptimizeFor(budget time.Duration) int {
+	deadline := time.Now().Add(budget)
+
+	for _, n := range t.refitQueue {
+		if !n.IsValid() {
+			continue
+		}
+
+		if n.IsLeaf() {
+			t.RefitVolume(n)
+			if n.HasParent() {
+				t.queueRefit(n.Parent)
+			}
+		} else {
+			t.queueRefit(n)
+		}
+	}
+	t.refitQueue = make([]*Node, 0)
+
+	// Branches are taken deepest first so each one is priced once its children are final, and the clock is
+	// only checked between chunks since a single branch is cheap
+	for done := 0; len(t.refitLevels) > 0; done++ {
+		if done > 0 && done%optimizeChunk == 0 && !time.Now().Before(deadline) {
+			return t.PendingOptimizations()
+		}
+
+		depth := len(t.refitLevels) - 1
+		level := t.refitLevels[depth]
+		if len(level) == 0 {
+			t.refitLevels = t.refitLevels[:depth]
+			continue
+		}
+
+		n := level[len(level)-1]
+		t.refitLevels[depth] = level[:len(level)-1]
+
+		// Nodes freed in between calls are taken off the pending set, and may since have been reused and queued again
+		if !t.refitPending[n] {
+			continue
+		}
+		delete(t.refitPending, n)
+
+		if !n.IsValidBranchNode() {
+			continue
+		}
+
+		t.ChildRefit(n, false)
+		t.queueRotation(n)
+
+		if n.HasParent() {
+			t.queueRefit(n.Parent)
+		}
+	}
+
+	for t.rotationQueue.Len() > 0 {
+		item := heap.Pop(&t.rotationQueue).(rotationItem)
+		n := item.node
+
+		if !t.rotationPending[n] {
+			continue
+		}
+		delete(t.rotationPending, n)
+
+		// The tree may have changed since the node was queued, so price it again and make way if it's now
+		// worth less than the next best one
+		gain, ok := t.rotationGain(n)
+		if !ok {
+			continue
+		}
+
+		if t.rotationQueue.Len() > 0 && gain < t.rotationQueue[0].gain {
+			t.pushRotation(n, gain)
+			continue
+		}
+
+		t.TryRotate(n)
+
+		// Rotating changed the grandchildren the parent would rotate with
+		if n.HasParent() {
+			t.queueRotation(n.Parent)
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+	}
+
+	return t.PendingOptimizations()
+}
+
+// PendingOptimizations returns how many nodes OptimizeFor still has to look at
+func (t *Tree) PendingOptimizations() int {
+	return len(t.refitQueue) + len(t.refitPending) + len(t.rotationPending)
+}
+
+// pendingRefits returns every node queued for a refit, including the branches OptimizeFor hasn't gotten to yet
+func (t *Tree) pendingRefits() []*Node {
+	nodes := append([]*Node{}, t.refitQueue...)
+	for depth := len(t.refitLevels) - 1; depth >= 0; depth-- {
+		for _, n := range t.refitLevels[depth] {
+			if t.refitPending[n] {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+func (t *Tree) queueRefit(n *Node) {
+	if t.refitPending[n] {
+		return
+	}
+
+	if t.refitPending == nil {
+		t.refitPending = make(map[*Node]bool)
+	}
+	t.refitPending[n] = true
+
+	for len(t.refitLevels) <= n.Depth {
+		t.refitLevels = append(t.refitLevels, nil)
+	}
+	t.refitLevels[n.Depth] = append(t.refitLevels[n.Depth], n)
+}
+
+// rotationGain returns how much surface area the rotation TryRotate would apply to n saves
+func (t *Tree) rotationGain(n *Node) (float64, bool) {
+	_, gain, ok := t.acceptedRotation(n)
+	return gain, ok
+}
+
+func (t *Tree) queueRotation(n *Node) {
+	if t.rotationPending[n] {
+		return
+	}
+
+	if gain, ok := t.rotationGain(n); ok {
+		t.pushRotation(n, gain)
+	}
+}
+
+func (t *Tree) pushRotation(n *Node, gain float64) {
+	if t.rotationPending == nil {
+		t.rotationPending = make(map[*Node]bool)
+	}
+
+	t.rotationPending[n] = true
+	heap.Push(&t.rotationQueue, rotationItem{n, gain})
+}
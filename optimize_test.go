@@ -0,0 +1,124 @@
+package dyntree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// optimizeForTree returns a tree with every entity moved a little and queued for optimization
+func optimizeForTree() *Tree {
+	rand.Seed(1717171717)
+	t := NewTree()
+
+	entities := make([]*Person, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		t.Add(entities[i])
+	}
+
+	for _, p := range entities {
+		p.position = p.position.Add(Vec3{rand.Float64()*20 - 10, rand.Float64()*20 - 10, rand.Float64()*20 - 10})
+		t.QueueForOptimize(p)
+	}
+
+	return t
+}
+
+func TestOptimizeFor(T *testing.T) {
+	t := optimizeForTree()
+
+	if t.PendingOptimizations() == 0 {
+		T.Fatal("Nothing queued after moving every entity")
+	}
+
+	// Refitting and pricing the branches is spread over the calls too, not just the rotations
+	if t.OptimizeFor(0); len(t.refitPending) == 0 {
+		T.Fatal("Every branch was priced despite a zero budget")
+	}
+	checkVolumes(T, t, t.rootNode)
+
+	// A zero budget still looks at a chunk of branches or one rotation per call, so this has to finish well before
+	// running out of calls
+	calls := 0
+	for pending := -1; pending != 0; calls++ {
+		if calls > AMMOUNT*10 {
+			T.Fatal("OptimizeFor isn't making progress", pending)
+		}
+
+		pending = t.OptimizeFor(0)
+
+		// Leaves are refit up front, so the tree has to be consistent in between calls
+		if calls%100 == 0 {
+			checkVolumes(T, t, t.rootNode)
+		}
+	}
+
+	if calls < 2 {
+		T.Fatal("Expected the work to be spread over several calls")
+	}
+
+	checkVolumes(T, t, t.rootNode)
+
+	if t.PendingOptimizations() != 0 {
+		T.Fatal("Work left over after OptimizeFor reported none", t.PendingOptimizations())
+	}
+
+	// Rotations are picked in a different order than Optimize would, but should be about as good in the end
+	twin := optimizeForTree()
+	twin.Optimize()
+	if t.SAHCost() > twin.SAHCost()*1.05 {
+		T.Fatal("OptimizeFor left a much worse tree than Optimize", t.SAHCost(), twin.SAHCost())
+	}
+
+	if len(t.Traverse(func(BoundingBox) bool { return true })) != AMMOUNT {
+		T.Fatal("Entities lost while optimizing")
+	}
+}
+
+func TestRotationAcceptance(T *testing.T) {
+	t := optimizeForTree()
+
+	// TryRotate has to apply exactly the rotations OptimizeFor prices, or the two would shape the tree differently
+	for _, n := range append([]*Node{}, t.nodes...) {
+		if !n.IsValidBranchNode() {
+			continue
+		}
+
+		_, priced := t.rotationGain(n)
+		before := [...]*Node{n.Left, n.Right, n.Left.Left, n.Left.Right, n.Right.Left, n.Right.Right}
+		t.TryRotate(n)
+
+		if rotated := before != [...]*Node{n.Left, n.Right, n.Left.Left, n.Left.Right, n.Right.Left, n.Right.Right}; rotated != priced {
+			T.Fatal("TryRotate and rotationGain disagree", rotated, priced)
+		}
+	}
+
+	checkVolumes(T, t, t.rootNode)
+}
+
+func TestOptimizeForFreedNode(T *testing.T) {
+	t := optimizeForTree()
+	for len(t.refitPending) > 0 || len(t.refitQueue) > 0 {
+		t.OptimizeFor(0)
+	}
+
+	var queued *Node
+	for n := range t.rotationPending {
+		queued = n
+		break
+	}
+	if queued == nil {
+		T.Fatal("No rotations queued")
+	}
+
+	// Once freed, the node could be reused anywhere, so whatever was queued for it has to go
+	pending := t.PendingOptimizations()
+	t.FreeNode(queued)
+
+	if t.rotationPending[queued] || t.PendingOptimizations() != pending-1 {
+		T.Fatal("Freed node is still queued for a rotation")
+	}
+}
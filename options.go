@@ -0,0 +1,126 @@
+package dyntree
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	DefaultMaxLeaves      = 1
+	DefaultPushdownFactor = 0.3
+)
+
+// TreeOptions tunes a Tree, DefaultTreeOptions gives the same tree as NewTree
+type TreeOptions struct {
+	// MaxLeaves is how many entities a leaf holds before it's split, defaulting to 1
+	MaxLeaves int
+	// Margin enlarges the volume stored for every entity on all sides, so it can move that far
+	// before the tree needs to be touched at all
+	Margin float64
+	// VelocityHorizon is how far ahead, in the time unit MovingEntity velocities are given in, the volume of
+	// a moving entity is stretched along its velocity
+	VelocityHorizon float64
+	// PushdownFactor is how much cheaper a merge-and-pushdown has to be than descending into a child while
+	// inserting, as a fraction of the cheaper child's cost. Lower values push down less often and 0 never does,
+	// defaulting to 0.3
+	PushdownFactor float64
+	// RotationThreshold is the fraction of surface area a rotation has to save before it's applied, defaulting to 0
+	RotationThreshold float64
+	// BuildWorkers is how many goroutines BuildTree can use, building serially when 0 or 1
+	BuildWorkers int
+	// TreeletPasses is how many rounds of treelet restructuring BuildTreeLinear runs after emitting its hierarchy
+	TreeletPasses int
+}
+
+// DefaultTreeOptions returns the options NewTree starts from before applying its Options
+func DefaultTreeOptions() TreeOptions {
+	return TreeOptions{
+		MaxLeaves:      DefaultMaxLeaves,
+		PushdownFactor: DefaultPushdownFactor,
+	}
+}
+
+// Option sets a single TreeOptions field for NewTree
+type Option func(*TreeOptions)
+
+func WithMaxLeaves(n int) Option {
+	return func(o *TreeOptions) { o.MaxLeaves = n }
+}
+
+func WithMargin(m float64) Option {
+	return func(o *TreeOptions) { o.Margin = m }
+}
+
+func WithVelocityHorizon(h float64) Option {
+	return func(o *TreeOptions) { o.VelocityHorizon = h }
+}
+
+func WithPushdownFactor(f float64) Option {
+	return func(o *TreeOptions) { o.PushdownFactor = f }
+}
+
+func WithRotationThreshold(r float64) Option {
+	return func(o *TreeOptions) { o.RotationThreshold = r }
+}
+
+func WithBuildWorkers(n int) Option {
+	return func(o *TreeOptions) { o.BuildWorkers = n }
+}
+
+func WithTreeletPasses(n int) Option {
+	return func(o *TreeOptions) { o.TreeletPasses = n }
+}
+
+func (o TreeOptions) Validate() error {
+	if o.MaxLeaves < 0 {
+		return fmt.Errorf("dyntree: max leaves must not be negative, got %d", o.MaxLeaves)
+	}
+
+	if o.Margin < 0 || math.IsNaN(o.Margin) || math.IsInf(o.Margin, 0) {
+		return fmt.Errorf("dyntree: margin must be finite and not negative, got %v", o.Margin)
+	}
+
+	if o.VelocityHorizon < 0 || math.IsNaN(o.VelocityHorizon) || math.IsInf(o.VelocityHorizon, 0) {
+		return fmt.Errorf("dyntree: velocity horizon must be finite and not negative, got %v", o.VelocityHorizon)
+	}
+
+	if !(o.PushdownFactor >= 0 && o.PushdownFactor <= 1) {
+		return fmt.Errorf("dyntree: pushdown factor must be between 0 and 1, got %v", o.PushdownFactor)
+	}
+
+	if !(o.RotationThreshold >= 0 && o.RotationThreshold < 1) {
+		return fmt.Errorf("dyntree: rotation threshold must be at least 0 and below 1, got %v", o.RotationThreshold)
+	}
+
+	if o.BuildWorkers < 0 {
+		return fmt.Errorf("dyntree: build workers must not be negative, got %d", o.BuildWorkers)
+	}
+
+	if o.TreeletPasses < 0 {
+		return fmt.Errorf("dyntree: treelet passes must not be negative, got %d", o.TreeletPasses)
+	}
+
+	return nil
+}
+
+// NewTreeWithOptions is NewTree for callers that would rather get an error than a panic on bad options
+func NewTreeWithOptions(o TreeOptions) (*Tree, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newTree(o), nil
+}
+
+// Options returns the options the tree was created with
+func (t *Tree) Options() TreeOptions {
+	return TreeOptions{
+		MaxLeaves:         t.maxLeaves,
+		Margin:            t.margin,
+		VelocityHorizon:   t.horizon,
+		PushdownFactor:    t.pushdownFactor,
+		RotationThreshold: t.rotationThreshold,
+		BuildWorkers:      t.buildWorkers,
+		TreeletPasses:     t.treeletPasses,
+	}
+}
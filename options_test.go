@@ -0,0 +1,76 @@
+package dyntree
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNewTreeOptions(T *testing.T) {
+	t := NewTree(WithMaxLeaves(4), WithMargin(0.5), WithVelocityHorizon(2), WithPushdownFactor(0.1), WithRotationThreshold(0.05))
+
+	if t.maxLeaves != 4 || t.margin != 0.5 || t.horizon != 2 || t.pushdownFactor != 0.1 || t.rotationThreshold != 0.05 {
+		T.Fatal("Options weren't applied", t.maxLeaves, t.margin, t.horizon, t.pushdownFactor, t.rotationThreshold)
+	}
+
+	t = NewTree()
+	if t.maxLeaves != DefaultMaxLeaves || t.pushdownFactor != DefaultPushdownFactor || t.rotationThreshold != 0 {
+		T.Fatal("Defaults weren't applied", t.maxLeaves, t.pushdownFactor, t.rotationThreshold)
+	}
+
+	if t = NewTree(WithPushdownFactor(0)); t.pushdownFactor != 0 {
+		T.Fatal("Explicit zero pushdown factor was replaced", t.pushdownFactor)
+	}
+
+	if t, _ = NewTreeWithOptions(DefaultTreeOptions()); t.Options() != NewTree().Options() {
+		T.Fatal("DefaultTreeOptions doesn't match NewTree", t.Options())
+	}
+
+	for _, o := range []TreeOptions{
+		{MaxLeaves: -1},
+		{Margin: -1},
+		{Margin: math.NaN()},
+		{VelocityHorizon: math.Inf(1)},
+		{PushdownFactor: 1.5},
+		{PushdownFactor: -0.1},
+		{RotationThreshold: 1},
+		{RotationThreshold: math.NaN()},
+	} {
+		if _, err := NewTreeWithOptions(o); err == nil {
+			T.Fatal("Invalid options were accepted", o)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			T.Fatal("NewTree didn't panic on invalid options")
+		}
+	}()
+	NewTree(WithMaxLeaves(-1))
+}
+
+func TestTunedTree(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree(WithPushdownFactor(0.5), WithRotationThreshold(0.5))
+
+	entities := make([]*Person, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		t.Add(entities[i])
+	}
+
+	for _, p := range entities {
+		p.position = p.position.Add(Vec3{float64(rand.Intn(50) - 25), float64(rand.Intn(50) - 25), 0})
+		t.QueueForOptimize(p)
+	}
+	t.Optimize()
+
+	checkVolumes(T, t, t.rootNode)
+
+	if got := len(t.Traverse(func(BoundingBox) bool { return true })); got != AMMOUNT {
+		T.Fatal("Entities lost", got)
+	}
+}
@@ -0,0 +1,193 @@
+package dyntree
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+// maxRasterPixels is the largest image the rasterizer will allocate, a little over a gigabyte of RGBA
+const maxRasterPixels = 1 << 28
+
+// Transform maps world space positions projected onto a plane to pixels
+type Transform struct {
+	Plane Plane
+	// OriginX and OriginY are the world coordinates on the plane that land on pixel (0, 0)
+	OriginX, OriginY float64
+	// Scale is how many pixels one world unit covers
+	Scale float64
+}
+
+// Pixel returns where v lands, as fractional pixel coordinates
+func (tf Transform) Pixel(v Vec3) (float64, float64) {
+	x, y := tf.Plane.Project(v)
+	return (x - tf.OriginX) * tf.Scale, (y - tf.OriginY) * tf.Scale
+}
+
+// Point returns the pixel v lands in
+func (tf Transform) Point(v Vec3) image.Point {
+	x, y := tf.Pixel(v)
+	return image.Point{clampPixel(x), clampPixel(y)}
+}
+
+// Rect returns the pixels covered by b, with Max inclusive of the last pixel like the rest of the rasterizer
+func (tf Transform) Rect(b BoundingBox) image.Rectangle {
+	return image.Rectangle{tf.Point(b.Min), tf.Point(b.Max)}
+}
+
+// Length returns how many pixels a world space distance covers
+func (tf Transform) Length(l float64) float64 {
+	return l * tf.Scale
+}
+
+// clampPixel floors a pixel coordinate, keeping anything absurdly far off screen within int range
+func clampPixel(v float64) int {
+	return int(math.Max(-math.MaxInt32, math.Min(math.Floor(v), math.MaxInt32)))
+}
+
+// Viewport is the area of the world to rasterize and the size of the image to rasterize it to
+type Viewport struct {
+	Plane Plane
+	// World is the area drawn, only its extent along the plane's axes matters. The zero value draws the whole tree.
+	World BoundingBox
+	// Width and Height are the size of the image in pixels, World is scaled uniformly to fit inside of it
+	Width, Height int
+}
+
+// Transform returns the mapping from world space to the pixels of the viewport's image
+func (vp Viewport) Transform() Transform {
+	return vp.fit(float64(vp.Width), float64(vp.Height))
+}
+
+// extent returns where World starts on the plane and how far it reaches along both axes
+func (vp Viewport) extent() (minX, minY, w, h float64) {
+	minX, minY = vp.Plane.Project(vp.World.Min)
+	maxX, maxY := vp.Plane.Project(vp.World.Max)
+
+	// A flat world still needs some extent to scale, so give it a unit in whichever direction it's missing
+	return minX, minY, math.Max(maxX-minX, 1), math.Max(maxY-minY, 1)
+}
+
+// fit maps World onto an image of the given size, which doesn't have to be a whole number of pixels
+func (vp Viewport) fit(width, height float64) Transform {
+	minX, minY, w, h := vp.extent()
+
+	return Transform{
+		Plane:   vp.Plane,
+		OriginX: minX,
+		OriginY: minY,
+		Scale:   math.Min(width/w, height/h),
+	}
+}
+
+// Render rasterizes the volume of every node and entity within the viewport. Entities implementing CustomDrawer
+// draw themselves instead.
+func (t *Tree) Render(vp Viewport) (*image.RGBA, error) {
+	if vp.World == (BoundingBox{}) {
+		vp.World = t.rootNode.Box
+	}
+
+	frame, err := newFrame(vp.Width, vp.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	t.rasterize(frame, vp.Transform())
+
+	return frame, nil
+}
+
+// WritePNG renders the viewport and encodes it to w as a PNG
+func (t *Tree) WritePNG(w io.Writer, vp Viewport) error {
+	frame, err := t.Render(vp)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, frame)
+}
+
+func newFrame(width, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 || float64(width)*float64(height) > maxRasterPixels {
+		return nil, fmt.Errorf("dyntree: can't rasterize a %dx%d image", width, height)
+	}
+
+	frame := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(frame, frame.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	return frame, nil
+}
+
+func (t *Tree) rasterize(frame *image.RGBA, tf Transform) {
+	bounds := frame.Bounds()
+
+	// Lines are clipped to the frame before they're walked, so zooming far into a huge world stays cheap
+	hline := func(x1, y, x2 int, c color.Color) {
+		if y < bounds.Min.Y || y >= bounds.Max.Y {
+			return
+		}
+		for x := imax(x1, bounds.Min.X); x <= x2 && x < bounds.Max.X; x++ {
+			frame.Set(x, y, c)
+		}
+	}
+
+	vline := func(x, y1, y2 int, c color.Color) {
+		if x < bounds.Min.X || x >= bounds.Max.X {
+			return
+		}
+		for y := imax(y1, bounds.Min.Y); y <= y2 && y < bounds.Max.Y; y++ {
+			frame.Set(x, y, c)
+		}
+	}
+
+	rect := func(r image.Rectangle, c color.Color) {
+		hline(r.Min.X, r.Min.Y, r.Max.X, c)
+		hline(r.Min.X, r.Max.Y, r.Max.X, c)
+		vline(r.Min.X, r.Min.Y, r.Max.Y, c)
+		vline(r.Max.X, r.Min.Y, r.Max.Y, c)
+	}
+
+	onScreen := func(r image.Rectangle) bool {
+		return r.Max.X >= bounds.Min.X && r.Min.X < bounds.Max.X && r.Max.Y >= bounds.Min.Y && r.Min.Y < bounds.Max.Y
+	}
+
+	entities := make([]Entity, 0)
+	stack := []*Node{t.rootNode}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		r := tf.Rect(n.Box)
+		if !onScreen(r) {
+			continue
+		}
+
+		rect(r, color.RGBA{255, 0, 0, 255})
+
+		if n.IsLeaf() {
+			entities = append(entities, t.Buckets[n.BucketIndex-1]...)
+		} else if n.IsValidBranchNode() {
+			stack = append(stack, n.Right, n.Left)
+		}
+	}
+
+	for _, e := range entities {
+		if d, ok := e.(CustomDrawer); ok {
+			d.DrawImage(frame, tf)
+			continue
+		}
+		rect(tf.Rect(BoxFromEntity(e)), color.RGBA{0, 255, 0, 255})
+	}
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package dyntree
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+type markedPerson struct {
+	Person
+}
+
+func (p *markedPerson) DrawImage(img *image.RGBA, tf Transform) {
+	pt := tf.Point(p.position)
+	img.Set(pt.X, pt.Y, color.RGBA{0, 0, 255, 255})
+}
+
+func countColor(img *image.RGBA, c color.RGBA) int {
+	ct := 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.RGBAAt(x, y) == c {
+				ct++
+			}
+		}
+	}
+	return ct
+}
+
+func TestRender(T *testing.T) {
+	// A world much smaller than a pixel per unit, partly at negative coordinates
+	small := NewTree()
+	small.Add(&Person{0.1, Vec3{-0.8, -0.8, 0}})
+	small.Add(&Person{0.1, Vec3{0.5, 0.25, 0}})
+	marked := &markedPerson{Person{0.1, Vec3{0, 0.5, 0}}}
+	small.Add(marked)
+
+	img, err := small.Render(Viewport{Width: 200, Height: 200})
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 200, 200) {
+		T.Fatal("Wrong image size", img.Bounds())
+	}
+	if countColor(img, color.RGBA{255, 0, 0, 255}) == 0 || countColor(img, color.RGBA{0, 255, 0, 255}) == 0 {
+		T.Fatal("Nodes or entities weren't drawn")
+	}
+
+	tf := Viewport{World: small.Root().Box, Width: 200, Height: 200}.Transform()
+	want := tf.Point(marked.position)
+	if img.RGBAAt(want.X, want.Y) != (color.RGBA{0, 0, 255, 255}) || countColor(img, color.RGBA{0, 0, 255, 255}) != 1 {
+		T.Fatal("Custom drawer wasn't handed the viewport transform")
+	}
+
+	// A world far bigger than any sane image, which Image can't draw but a viewport can
+	huge := NewTree()
+	huge.Add(&Person{1000, Vec3{-1e6, -1e6, 0}})
+	huge.Add(&Person{1000, Vec3{1e6, 1e6, 0}})
+
+	if err := huge.Image(filepath.Join(T.TempDir(), "huge.bmp")); err == nil {
+		T.Fatal("Image tried to draw a world two million pixels across")
+	}
+
+	buf := bytes.Buffer{}
+	if err := huge.WritePNG(&buf, Viewport{Plane: XZ, Width: 256, Height: 64}); err != nil {
+		T.Fatal(err)
+	}
+
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if decoded.Bounds() != image.Rect(0, 0, 256, 64) {
+		T.Fatal("Wrong PNG size", decoded.Bounds())
+	}
+
+	// Zoomed in on a corner, where most of every box is far off the image
+	corner := BoundingBox{Vec3{-1.01e6, -1.01e6, 0}, Vec3{-0.99e6, -0.99e6, 0}}
+	if _, err := huge.Render(Viewport{World: corner, Width: 64, Height: 64}); err != nil {
+		T.Fatal(err)
+	}
+
+	if _, err := huge.Render(Viewport{Width: 0, Height: 64}); err == nil {
+		T.Fatal("Rendered an image with no width")
+	}
+}
@@ -0,0 +1,171 @@
+package dyntree
+
+import (
+	"math"
+	"sort"
+)
+
+// RayHit is an entity struck by a ray along with the distance from the ray origin to where it was entered
+type RayHit struct {
+	Entity   Entity
+	Distance float64
+}
+
+type rayQuery struct {
+	origin  Vec3
+	dir     Vec3
+	inv     Vec3
+	maxDist float64
+}
+
+func newRayQuery(origin, dir Vec3, maxDist float64) (*rayQuery, bool) {
+	l := dir.Length()
+	if l == 0 || math.IsNaN(l) {
+		return nil, false
+	}
+
+	if maxDist <= 0 {
+		maxDist = math.Inf(1)
+	}
+
+	dir = dir.Scale(1 / l)
+
+	return &rayQuery{
+		origin:  origin,
+		dir:     dir,
+		inv:     Vec3{1 / dir.X, 1 / dir.Y, 1 / dir.Z},
+		maxDist: maxDist,
+	}, true
+}
+
+// Entry returns the distance along the ray at which it enters b, or 0 if the origin is already inside of it
+func (r *rayQuery) Entry(b BoundingBox) (float64, bool) {
+	tmin, tmax, ok := slab(r.origin.X, r.dir.X, r.inv.X, b.Min.X, b.Max.X, 0, r.maxDist)
+	if !ok {
+		return 0, false
+	}
+	tmin, tmax, ok = slab(r.origin.Y, r.dir.Y, r.inv.Y, b.Min.Y, b.Max.Y, tmin, tmax)
+	if !ok {
+		return 0, false
+	}
+	tmin, _, ok = slab(r.origin.Z, r.dir.Z, r.inv.Z, b.Min.Z, b.Max.Z, tmin, tmax)
+	if !ok {
+		return 0, false
+	}
+
+	return tmin, true
+}
+
+func slab(origin, dir, inv, min, max, tmin, tmax float64) (float64, float64, bool) {
+	if dir == 0 {
+		// Parallel to the slab, so we're either always inside of it or never
+		return tmin, tmax, origin >= min && origin <= max
+	}
+
+	t1 := (min - origin) * inv
+	t2 := (max - origin) * inv
+	if t1 > t2 {
+		t1, t2 = t2, t1
+	}
+
+	if t1 > tmin {
+		tmin = t1
+	}
+	if t2 < tmax {
+		tmax = t2
+	}
+
+	return tmin, tmax, tmin <= tmax
+}
+
+// RayCast returns every entity the ray passes through within maxDist, sorted nearest first.
+// A maxDist <= 0 casts an unbounded ray.
+func (t *Tree) RayCast(origin, dir Vec3, maxDist float64) []RayHit {
+	r, ok := newRayQuery(origin, dir, maxDist)
+	if !ok {
+		return nil
+	}
+
+	hits := t.rayCastNode(t.rootNode, r, nil)
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+
+	return hits
+}
+
+func (t *Tree) rayCastNode(cur *Node, r *rayQuery, hits []RayHit) []RayHit {
+	if !cur.IsValid() {
+		return hits
+	}
+
+	if _, ok := r.Entry(cur.Box); !ok {
+		return hits
+	}
+
+	if cur.IsLeaf() {
+		for _, e := range t.Buckets[cur.BucketIndex-1] {
+			if d, ok := r.Entry(BoxFromEntity(e)); ok {
+				hits = append(hits, RayHit{e, d})
+			}
+		}
+		return hits
+	}
+
+	hits = t.rayCastNode(cur.Left, r, hits)
+	return t.rayCastNode(cur.Right, r, hits)
+}
+
+// RayCastFirst returns the nearest entity the ray passes through within maxDist.
+// Nearer children are visited first and anything further than the best hit so far is skipped.
+func (t *Tree) RayCastFirst(origin, dir Vec3, maxDist float64) (RayHit, bool) {
+	r, ok := newRayQuery(origin, dir, maxDist)
+	if !ok || !t.rootNode.IsValid() {
+		return RayHit{}, false
+	}
+
+	d, ok := r.Entry(t.rootNode.Box)
+	if !ok {
+		return RayHit{}, false
+	}
+
+	best := RayHit{}
+	t.rayCastFirstNode(t.rootNode, d, r, &best)
+
+	return best, best.Entity != nil
+}
+
+func (t *Tree) rayCastFirstNode(cur *Node, entry float64, r *rayQuery, best *RayHit) {
+	// The best hit shrinks maxDist as we go, so anything we queued up before finding it may now be too far
+	if !cur.IsValid() || entry > r.maxDist {
+		return
+	}
+
+	if cur.IsLeaf() {
+		for _, e := range t.Buckets[cur.BucketIndex-1] {
+			if d, ok := r.Entry(BoxFromEntity(e)); ok && (best.Entity == nil || d < best.Distance) {
+				best.Entity = e
+				best.Distance = d
+				r.maxDist = d
+			}
+		}
+		return
+	}
+
+	near, far := cur.Left, cur.Right
+	nearD, nearOk := r.Entry(near.Box)
+	farD, farOk := r.Entry(far.Box)
+
+	if farOk && (!nearOk || farD < nearD) {
+		near, far = far, near
+		nearD, farD = farD, nearD
+		nearOk, farOk = farOk, nearOk
+	}
+
+	if nearOk {
+		t.rayCastFirstNode(near, nearD, r, best)
+	}
+
+	if farOk {
+		t.rayCastFirstNode(far, farD, r, best)
+	}
+}
@@ -0,0 +1,59 @@
+package dyntree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRayCast(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree()
+
+	entities := make([]*Person, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), 0},
+		}
+		t.Add(entities[i])
+	}
+
+	origin := Vec3{0, 0, 0}
+	dir := Vec3{45, 45, 0}
+
+	hits := t.RayCast(origin, dir, 0)
+
+	r, _ := newRayQuery(origin, dir, 0)
+	expected := 0
+	for _, e := range entities {
+		if _, ok := r.Entry(BoxFromEntity(e)); ok {
+			expected++
+		}
+	}
+
+	if len(hits) != expected {
+		T.Fatal("BVH/Loop disagree", len(hits), expected)
+	}
+
+	for i := 1; i < len(hits); i++ {
+		if hits[i-1].Distance > hits[i].Distance {
+			T.Fatal("Hits not sorted by distance")
+		}
+	}
+
+	first, ok := t.RayCastFirst(origin, dir, 0)
+	if !ok || first.Distance != hits[0].Distance {
+		T.Fatal("RayCastFirst did not return the nearest hit", first, hits[0])
+	}
+
+	limited := t.RayCast(origin, dir, hits[0].Distance+1)
+	for _, h := range limited {
+		if h.Distance > hits[0].Distance+1 {
+			T.Fatal("Hit beyond max distance", h.Distance)
+		}
+	}
+
+	if _, ok := t.RayCastFirst(Vec3{-10, -10, 0}, Vec3{-1, 0, 0}, 0); ok {
+		T.Fatal("Ray pointing away from every entity hit something")
+	}
+}
@@ -0,0 +1,417 @@
+package dyntree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	snapshotMagic   = "DYNT"
+	snapshotVersion = 1
+)
+
+// EntityCodec maps entities to stable IDs and back so a tree can be saved with WriteSnapshot and restored with
+// ReadSnapshot, typically in another process that loads the same entities from elsewhere
+type EntityCodec interface {
+	EncodeEntity(e Entity) (uint64, error)
+	DecodeEntity(id uint64) (Entity, error)
+}
+
+// WriteSnapshot saves the tree's exact layout to w, down to the node and bucket indices and free lists, so a
+// tree restored by ReadSnapshot answers every query identically and keeps evolving the same way
+func (t *Tree) WriteSnapshot(w io.Writer, codec EntityCodec) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+
+	sw.bytes([]byte(snapshotMagic))
+	sw.u64(snapshotVersion)
+
+	o := t.Options()
+	sw.i64(o.MaxLeaves)
+	sw.f64(o.Margin)
+	sw.f64(o.VelocityHorizon)
+	sw.f64(o.PushdownFactor)
+	sw.f64(o.RotationThreshold)
+	sw.i64(o.BuildWorkers)
+	sw.i64(o.TreeletPasses)
+	sw.i64(t.maxDepth)
+
+	sw.i64(t.rootNode.NodeIndex)
+
+	sw.i64(len(t.nodes))
+	for _, n := range t.nodes {
+		sw.box(n.Box)
+		sw.node(n.Parent)
+		sw.node(n.Left)
+		sw.node(n.Right)
+		sw.i64(n.BucketIndex)
+		sw.i64(n.Depth)
+		sw.i64(int(n.State))
+	}
+
+	// Freed buckets may still hold entities that have since left the tree, which the codec can't be expected to
+	// know about, and they're never read before being filled again anyway
+	freeBuckets := make(map[int]bool, len(t.unusedBucketIndicies))
+	for _, i := range t.unusedBucketIndicies {
+		freeBuckets[i] = true
+	}
+
+	sw.i64(len(t.Buckets))
+	for i, b := range t.Buckets {
+		if freeBuckets[i+1] {
+			sw.i64(0)
+			continue
+		}
+
+		sw.i64(len(b))
+		for _, e := range b {
+			id, err := codec.EncodeEntity(e)
+			if err != nil {
+				return fmt.Errorf("dyntree: encoding entity: %w", err)
+			}
+			sw.u64(id)
+		}
+	}
+
+	sw.i64s(t.unusedNodeIndicies)
+	sw.i64s(t.unusedBucketIndicies)
+
+	refits := t.pendingRefits()
+	sw.i64(len(refits))
+	for _, n := range refits {
+		sw.node(n)
+	}
+
+	// Entries left behind by freed nodes are skipped, OptimizeFor would never act on them
+	rotations := make([]rotationItem, 0, len(t.rotationPending))
+	written := make(map[*Node]bool, len(t.rotationPending))
+	for _, item := range t.rotationQueue {
+		if t.rotationPending[item.node] && !written[item.node] {
+			written[item.node] = true
+			rotations = append(rotations, item)
+		}
+	}
+
+	sw.i64(len(rotations))
+	for _, item := range rotations {
+		sw.node(item.node)
+		sw.f64(item.gain)
+	}
+
+	if sw.err != nil {
+		return sw.err
+	}
+
+	return sw.w.Flush()
+}
+
+// ReadSnapshot restores a tree saved by WriteSnapshot, using codec to turn the saved IDs back into entities.
+// The restored tree is validated before it's returned, so a damaged snapshot gives an error rather than a tree
+// that breaks later on.
+func ReadSnapshot(r io.Reader, codec EntityCodec) (*Tree, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	if magic := sr.bytes(len(snapshotMagic)); sr.err == nil && string(magic) != snapshotMagic {
+		return nil, errors.New("dyntree: not a tree snapshot")
+	}
+	if v := sr.u64(); sr.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("dyntree: unsupported snapshot version %d", v)
+	}
+
+	o := TreeOptions{
+		MaxLeaves:         sr.i64(),
+		Margin:            sr.f64(),
+		VelocityHorizon:   sr.f64(),
+		PushdownFactor:    sr.f64(),
+		RotationThreshold: sr.f64(),
+		BuildWorkers:      sr.i64(),
+		TreeletPasses:     sr.i64(),
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+
+	t, err := NewTreeWithOptions(o)
+	if err != nil {
+		return nil, err
+	}
+
+	t.maxDepth = sr.i64()
+	root := sr.i64()
+
+	// Links can point forward, so every node has to exist before any of them are linked up
+	type nodeLinks struct{ parent, left, right int }
+	links := make([]nodeLinks, 0)
+	t.nodes = make([]*Node, 0)
+
+	for i, ct := 0, sr.count(); i < ct && sr.err == nil; i++ {
+		n := &Node{NodeIndex: i, Box: sr.box()}
+		l := nodeLinks{sr.i64(), sr.i64(), sr.i64()}
+		n.BucketIndex = sr.i64()
+		n.Depth = sr.i64()
+		n.State = NodeState(sr.i64())
+
+		t.nodes = append(t.nodes, n)
+		links = append(links, l)
+	}
+
+	for i, l := range links {
+		t.nodes[i].Parent = sr.link(t.nodes, l.parent)
+		t.nodes[i].Left = sr.link(t.nodes, l.left)
+		t.nodes[i].Right = sr.link(t.nodes, l.right)
+	}
+
+	if t.rootNode = sr.link(t.nodes, root); t.rootNode == nil {
+		sr.fail(errors.New("missing root"))
+	}
+
+	t.Buckets = make([][]Entity, 0)
+	for i, ct := 0, sr.count(); i < ct && sr.err == nil; i++ {
+		b := make([]Entity, 0)
+		for j, ct := 0, sr.count(); j < ct && sr.err == nil; j++ {
+			id := sr.u64()
+			if sr.err != nil {
+				break
+			}
+
+			e, err := codec.DecodeEntity(id)
+			if err != nil {
+				return nil, fmt.Errorf("dyntree: decoding entity %d: %w", id, err)
+			}
+			if e == nil {
+				return nil, fmt.Errorf("dyntree: entity %d decoded to nil", id)
+			}
+			b = append(b, e)
+		}
+		t.Buckets = append(t.Buckets, b)
+	}
+
+	// Bucket indices are 1 based like BucketIndex
+	t.unusedNodeIndicies = sr.i64s(0, len(t.nodes)-1)
+	t.unusedBucketIndicies = sr.i64s(1, len(t.Buckets))
+
+	t.refitQueue = make([]*Node, 0)
+	for i, ct := 0, sr.count(); i < ct && sr.err == nil; i++ {
+		if n := sr.node(t.nodes); n != nil {
+			t.refitQueue = append(t.refitQueue, n)
+		}
+	}
+
+	for i, ct := 0, sr.count(); i < ct && sr.err == nil; i++ {
+		n, gain := sr.node(t.nodes), sr.f64()
+		if n != nil {
+			t.pushRotation(n, gain)
+		}
+	}
+
+	if sr.err != nil {
+		return nil, sr.err
+	}
+
+	// Only leaves still in the tree own their buckets, freed ones may point at buckets that were reused since
+	freeNodes := make(map[int]bool, len(t.unusedNodeIndicies))
+	for _, i := range t.unusedNodeIndicies {
+		freeNodes[i] = true
+	}
+	for _, n := range t.nodes {
+		if freeNodes[n.NodeIndex] || !n.IsLeaf() || n.BucketIndex < 1 || n.BucketIndex > len(t.Buckets) {
+			continue
+		}
+		for _, e := range t.Buckets[n.BucketIndex-1] {
+			t.MapLeaf(e, n)
+		}
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("dyntree: restoring snapshot: %w", err)
+	}
+
+	return t, nil
+}
+
+// Snapshot pairs a tree with the codec for its entities, so it can be saved and restored through io.WriterTo and
+// io.ReaderFrom
+type Snapshot struct {
+	Tree  *Tree
+	Codec EntityCodec
+}
+
+// WriteTo saves Tree to w with WriteSnapshot
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := s.Tree.WriteSnapshot(cw, s.Codec)
+	return cw.n, err
+}
+
+// ReadFrom replaces Tree with the one ReadSnapshot restores from r, leaving it untouched on error
+func (s *Snapshot) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	t, err := ReadSnapshot(cr, s.Codec)
+	if err == nil {
+		s.Tree = t
+	}
+	return cr.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// snapshotWriter writes little endian values, remembering the first error so callers only check once at the end
+type snapshotWriter struct {
+	w   *bufio.Writer
+	buf [8]byte
+	err error
+}
+
+func (s *snapshotWriter) bytes(b []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *snapshotWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(s.buf[:], v)
+	s.bytes(s.buf[:])
+}
+
+func (s *snapshotWriter) i64(v int) {
+	s.u64(uint64(int64(v)))
+}
+
+func (s *snapshotWriter) i64s(vs []int) {
+	s.i64(len(vs))
+	for _, v := range vs {
+		s.i64(v)
+	}
+}
+
+func (s *snapshotWriter) f64(v float64) {
+	s.u64(math.Float64bits(v))
+}
+
+func (s *snapshotWriter) box(b BoundingBox) {
+	for _, v := range []float64{b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z} {
+		s.f64(v)
+	}
+}
+
+func (s *snapshotWriter) node(n *Node) {
+	if n == nil {
+		s.i64(-1)
+	} else {
+		s.i64(n.NodeIndex)
+	}
+}
+
+// snapshotReader is the reading side of snapshotWriter, returning zero values once anything has gone wrong
+type snapshotReader struct {
+	r   *bufio.Reader
+	buf [8]byte
+	err error
+}
+
+func (s *snapshotReader) fail(err error) {
+	if s.err == nil {
+		s.err = fmt.Errorf("dyntree: reading snapshot: %w", err)
+	}
+}
+
+func (s *snapshotReader) bytes(n int) []byte {
+	b := make([]byte, n)
+	if s.err != nil {
+		return b
+	}
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		s.fail(err)
+	}
+	return b
+}
+
+func (s *snapshotReader) u64() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		s.fail(err)
+		return 0
+	}
+	return binary.LittleEndian.Uint64(s.buf[:])
+}
+
+func (s *snapshotReader) i64() int {
+	return int(int64(s.u64()))
+}
+
+// count reads a length. Nothing is allocated up front based on it, so a damaged snapshot runs out of data
+// long before it can make us run out of memory.
+func (s *snapshotReader) count() int {
+	n := s.i64()
+	if n < 0 {
+		s.fail(fmt.Errorf("bad length %d", n))
+		return 0
+	}
+	return n
+}
+
+// i64s reads a list of indices, each of which has to be between lo and hi inclusive
+func (s *snapshotReader) i64s(lo, hi int) []int {
+	vs := make([]int, 0)
+	for i, ct := 0, s.count(); i < ct && s.err == nil; i++ {
+		v := s.i64()
+		if v < lo || v > hi {
+			s.fail(fmt.Errorf("index %d out of range", v))
+			break
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func (s *snapshotReader) f64() float64 {
+	return math.Float64frombits(s.u64())
+}
+
+func (s *snapshotReader) box() BoundingBox {
+	return BoundingBox{
+		Min: Vec3{s.f64(), s.f64(), s.f64()},
+		Max: Vec3{s.f64(), s.f64(), s.f64()},
+	}
+}
+
+func (s *snapshotReader) node(nodes []*Node) *Node {
+	return s.link(nodes, s.i64())
+}
+
+// link resolves a node index written by snapshotWriter.node, where -1 stands for nil
+func (s *snapshotReader) link(nodes []*Node, i int) *Node {
+	if i == -1 || s.err != nil {
+		return nil
+	}
+	if i < 0 || i >= len(nodes) {
+		s.fail(fmt.Errorf("node index %d out of range", i))
+		return nil
+	}
+	return nodes[i]
+}
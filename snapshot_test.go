@@ -0,0 +1,165 @@
+package dyntree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type entityIndex []Entity
+
+func (ix entityIndex) EncodeEntity(e Entity) (uint64, error) {
+	for i, o := range ix {
+		if o == e {
+			return uint64(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown entity %v", e)
+}
+
+func (ix entityIndex) DecodeEntity(id uint64) (Entity, error) {
+	if id >= uint64(len(ix)) {
+		return nil, fmt.Errorf("unknown id %d", id)
+	}
+	return ix[id], nil
+}
+
+// nilCodec decodes every ID to nothing at all
+type nilCodec struct {
+	entityIndex
+}
+
+func (nilCodec) DecodeEntity(uint64) (Entity, error) {
+	return nil, nil
+}
+
+func sameAnswers(T *testing.T, a, b *Tree) {
+	if !reflect.DeepEqual(a.Stats(), b.Stats()) {
+		T.Fatal("Stats differ", a.Stats(), b.Stats())
+	}
+
+	sameQueries(T, a, b)
+}
+
+func sameQueries(T *testing.T, a, b *Tree) {
+	for i := 0; i < 100; i++ {
+		min := Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))}
+		box := BoundingBox{min, min.Add(Vec3{100, 100, 100})}
+		test := func(b BoundingBox) bool { return b.Intersects(box) }
+
+		if !reflect.DeepEqual(a.Traverse(test), b.Traverse(test)) {
+			T.Fatal("Traversals differ")
+		}
+
+		dir := Vec3{rand.Float64() - 0.5, rand.Float64() - 0.5, rand.Float64() - 0.5}
+		if !reflect.DeepEqual(a.RayCast(min, dir, 0), b.RayCast(min, dir, 0)) {
+			T.Fatal("Ray casts differ")
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(T *testing.T) {
+	ix := entityIndex(randomEntities(2000, 1000))
+	t := NewTree(WithMaxLeaves(4), WithMargin(1))
+
+	for _, e := range ix[:1500] {
+		t.Add(e)
+	}
+	for _, e := range ix[:300] {
+		t.Remove(e)
+	}
+
+	// Leave some work queued so it has to survive the round trip too
+	for _, e := range ix[300:600] {
+		p := e.(*Person)
+		p.position = p.position.Add(Vec3{5, 0, 0})
+		t.QueueForOptimize(p)
+	}
+
+	buf := bytes.Buffer{}
+	if err := t.WriteSnapshot(&buf, ix); err != nil {
+		T.Fatal(err)
+	}
+
+	restored, err := ReadSnapshot(bytes.NewReader(buf.Bytes()), ix)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	if restored.Options() != t.Options() || restored.PendingOptimizations() != t.PendingOptimizations() {
+		T.Fatal("Options or pending work lost")
+	}
+
+	sameAnswers(T, t, restored)
+
+	// The io.WriterTo and io.ReaderFrom pair writes and reads exactly the same bytes
+	wrapped := bytes.Buffer{}
+	if n, err := (&Snapshot{t, ix}).WriteTo(&wrapped); err != nil || n != int64(wrapped.Len()) {
+		T.Fatal("WriteTo failed", n, err)
+	}
+	if !bytes.Equal(wrapped.Bytes(), buf.Bytes()) {
+		T.Fatal("WriteTo doesn't match WriteSnapshot")
+	}
+
+	s := &Snapshot{Codec: ix}
+	if n, err := s.ReadFrom(&wrapped); err != nil || n != int64(buf.Len()) {
+		T.Fatal("ReadFrom failed", n, err)
+	}
+	sameAnswers(T, t, s.Tree)
+
+	// Identical layouts and free lists should keep the trees identical as they keep changing
+	for _, tree := range []*Tree{t, restored} {
+		tree.OptimizeFor(0)
+		for _, e := range ix[1500:] {
+			tree.Add(e)
+		}
+		tree.Optimize()
+	}
+
+	sameAnswers(T, t, restored)
+}
+
+func TestSnapshotDamaged(T *testing.T) {
+	ix := entityIndex(randomEntities(100, 100))
+	t := BuildTree(ix)
+
+	buf := bytes.Buffer{}
+	if err := t.WriteSnapshot(&buf, ix); err != nil {
+		T.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for n := 0; n < len(data); n += 7 {
+		if _, err := ReadSnapshot(bytes.NewReader(data[:n]), ix); err == nil {
+			T.Fatal("Truncated snapshot restored without error at", n)
+		}
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader(data), ix[:50]); err == nil {
+		T.Fatal("Snapshot restored with entities the codec doesn't know")
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader(data), nilCodec{ix}); err == nil {
+		T.Fatal("Snapshot restored with nil entities")
+	}
+
+	if err := t.WriteSnapshot(&bytes.Buffer{}, ix[:50]); err == nil {
+		T.Fatal("Snapshot written with entities the codec doesn't know")
+	}
+
+	for _, free := range []*[]int{&t.unusedNodeIndicies, &t.unusedBucketIndicies} {
+		*free = append(*free, 99999)
+
+		buf.Reset()
+		if err := t.WriteSnapshot(&buf, ix); err != nil {
+			T.Fatal(err)
+		}
+		if _, err := ReadSnapshot(bytes.NewReader(buf.Bytes()), ix); err == nil {
+			T.Fatal("Snapshot restored with a free list index out of range")
+		}
+
+		*free = (*free)[:len(*free)-1]
+	}
+}
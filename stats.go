@@ -0,0 +1,81 @@
+package dyntree
+
+// TreeStats is a snapshot of the shape and quality of a tree, cheap enough to collect every few seconds
+type TreeStats struct {
+	Nodes    int
+	Leaves   int
+	Entities int
+
+	MinLeafDepth int
+	AvgLeafDepth float64
+	MaxLeafDepth int
+
+	// SAHCost is the same normalized cost SAHCost returns
+	SAHCost float64
+
+	// FreeNodes and FreeBuckets are how many slots are waiting to be reused in the node and bucket free lists
+	FreeNodes   int
+	FreeBuckets int
+
+	// BucketFill counts leaves by how many entities they hold, so BucketFill[2] is the number of leaves with 2
+	BucketFill []int
+
+	// OverlappingSiblings is how many branches have children whose volumes overlap, each of which means
+	// queries landing in the overlap have to descend both ways
+	OverlappingSiblings int
+}
+
+// Stats walks the whole tree and reports how healthy it is
+func (t *Tree) Stats() TreeStats {
+	s := TreeStats{
+		FreeNodes:   len(t.unusedNodeIndicies),
+		FreeBuckets: len(t.unusedBucketIndicies),
+		SAHCost:     t.SAHCost(),
+	}
+
+	if !t.rootNode.IsValid() {
+		return s
+	}
+
+	depths := 0
+	stack := []*Node{t.rootNode}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		s.Nodes++
+
+		if n.IsLeaf() {
+			ct := t.ItemCount(n)
+
+			s.Leaves++
+			s.Entities += ct
+			depths += n.Depth
+
+			if s.Leaves == 1 || n.Depth < s.MinLeafDepth {
+				s.MinLeafDepth = n.Depth
+			}
+			if n.Depth > s.MaxLeafDepth {
+				s.MaxLeafDepth = n.Depth
+			}
+
+			for len(s.BucketFill) <= ct {
+				s.BucketFill = append(s.BucketFill, 0)
+			}
+			s.BucketFill[ct]++
+
+			continue
+		}
+
+		if n.Left.Box.Intersects(n.Right.Box) {
+			s.OverlappingSiblings++
+		}
+
+		stack = append(stack, n.Left, n.Right)
+	}
+
+	s.AvgLeafDepth = float64(depths) / float64(s.Leaves)
+
+	return s
+}
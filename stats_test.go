@@ -0,0 +1,50 @@
+package dyntree
+
+import "testing"
+
+func TestStats(T *testing.T) {
+	es := randomEntities(1000, 1000)
+	t := BuildTree(es, WithMaxLeaves(4))
+
+	for _, e := range es[:100] {
+		t.Remove(e)
+	}
+
+	s := t.Stats()
+
+	if s.Entities != 900 {
+		T.Fatal("Wrong entity count", s.Entities)
+	}
+	if s.Nodes != 2*s.Leaves-1 {
+		T.Fatal("Node count doesn't match a binary tree of this many leaves", s.Nodes, s.Leaves)
+	}
+	if s.FreeNodes == 0 {
+		T.Fatal("Removals should have freed some nodes")
+	}
+	if s.SAHCost != t.SAHCost() {
+		T.Fatal("SAH cost doesn't match the tree's", s.SAHCost, t.SAHCost())
+	}
+
+	if !(float64(s.MinLeafDepth) <= s.AvgLeafDepth && s.AvgLeafDepth <= float64(s.MaxLeafDepth)) {
+		T.Fatal("Leaf depths out of order", s.MinLeafDepth, s.AvgLeafDepth, s.MaxLeafDepth)
+	}
+
+	leaves, entities := 0, 0
+	for fill, ct := range s.BucketFill {
+		leaves += ct
+		entities += fill * ct
+	}
+	if leaves != s.Leaves || entities != s.Entities {
+		T.Fatal("Bucket fill histogram doesn't add up", s.BucketFill)
+	}
+
+	apart := BuildTree([]Entity{&Person{1, Vec3{0, 0, 0}}, &Person{1, Vec3{10, 0, 0}}})
+	if ct := apart.Stats().OverlappingSiblings; ct != 0 {
+		T.Fatal("Separate siblings counted as overlapping", ct)
+	}
+
+	overlapping := BuildTree([]Entity{&Person{1, Vec3{0, 0, 0}}, &Person{1, Vec3{1, 0, 0}}})
+	if ct := overlapping.Stats().OverlappingSiblings; ct != 1 {
+		T.Fatal("Overlapping siblings not counted", ct)
+	}
+}
@@ -0,0 +1,136 @@
+package dyntree
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Plane is the pair of axes a 3D tree is projected onto when it's drawn
+type Plane int
+
+const (
+	XY Plane = iota
+	XZ
+	YZ
+)
+
+// Project returns the coordinates of v on the plane
+func (p Plane) Project(v Vec3) (float64, float64) {
+	switch p {
+	case XZ:
+		return v.X, v.Z
+	case YZ:
+		return v.Y, v.Z
+	default:
+		return v.X, v.Y
+	}
+}
+
+// DefaultDepthColors are the outline colours WriteSVG gives nodes at each depth, repeating once it runs out
+var DefaultDepthColors = []string{"#e6194b", "#f58231", "#ffe119", "#bfef45", "#3cb44b", "#42d4f4", "#4363d8", "#911eb4", "#f032e6"}
+
+// SVGOptions controls how WriteSVG draws the tree
+type SVGOptions struct {
+	Plane Plane
+	// Width and Height bound the size of the image, the tree is scaled uniformly to fit inside of them.
+	// When only one is set the other follows the aspect ratio of the tree, and when neither is the width is 1024.
+	Width, Height float64
+	// DepthColors overrides DefaultDepthColors
+	DepthColors []string
+	// EntityColor is the colour entities are drawn in, defaulting to white
+	EntityColor string
+	// Background fills the image, defaulting to black
+	Background string
+}
+
+// WriteSVG draws the volume of every node and entity projected onto a plane as an SVG image, with a tooltip
+// describing each one
+func (t *Tree) WriteSVG(w io.Writer, opts SVGOptions) error {
+	if len(opts.DepthColors) == 0 {
+		opts.DepthColors = DefaultDepthColors
+	}
+	if opts.EntityColor == "" {
+		opts.EntityColor = "white"
+	}
+	if opts.Background == "" {
+		opts.Background = "black"
+	}
+
+	// The colours end up inside quoted attributes, so anything that could close the quote or open a tag is escaped
+	depthColors := make([]string, len(opts.DepthColors))
+	for i, c := range opts.DepthColors {
+		depthColors[i] = escapeAttr(c)
+	}
+	opts.DepthColors = depthColors
+	opts.EntityColor = escapeAttr(opts.EntityColor)
+	opts.Background = escapeAttr(opts.Background)
+
+	vp := Viewport{Plane: opts.Plane, World: t.rootNode.Box}
+	_, _, ww, wh := vp.extent()
+
+	width, height := opts.Width, opts.Height
+	switch {
+	case width <= 0 && height <= 0:
+		width = 1024
+		height = width * wh / ww
+	case width <= 0:
+		width = height * ww / wh
+	case height <= 0:
+		height = width * wh / ww
+	}
+
+	tf := vp.fit(width, height)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.6g\" height=\"%.6g\" viewBox=\"0 0 %.6g %.6g\">\n", width, height, width, height)
+	fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"%s\"/>\n", opts.Background)
+
+	title := func(format string, args ...interface{}) {
+		fmt.Fprint(bw, "<title>")
+		xml.EscapeText(bw, []byte(fmt.Sprintf(format, args...)))
+		fmt.Fprint(bw, "</title>")
+	}
+
+	entities := make([]Entity, 0)
+	stack := []*Node{t.rootNode}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		x1, y1 := tf.Pixel(n.Box.Min)
+		x2, y2 := tf.Pixel(n.Box.Max)
+
+		fmt.Fprintf(bw, "<rect x=\"%.6g\" y=\"%.6g\" width=\"%.6g\" height=\"%.6g\" fill=\"none\" stroke=\"%s\">",
+			x1, y1, x2-x1, y2-y1, opts.DepthColors[n.Depth%len(opts.DepthColors)])
+		title("#%d depth %d surface area %.4g", n.NodeIndex, n.Depth, n.Box.SurfaceArea())
+		fmt.Fprintln(bw, "</rect>")
+
+		if n.IsLeaf() {
+			entities = append(entities, t.Buckets[n.BucketIndex-1]...)
+		} else if n.IsValidBranchNode() {
+			stack = append(stack, n.Right, n.Left)
+		}
+	}
+
+	for _, e := range entities {
+		x, y := tf.Pixel(e.Position())
+
+		fmt.Fprintf(bw, "<circle cx=\"%.6g\" cy=\"%.6g\" r=\"%.6g\" fill=\"none\" stroke=\"%s\">", x, y, tf.Length(e.Radius()), opts.EntityColor)
+		title("%v", e)
+		fmt.Fprintln(bw, "</circle>")
+	}
+
+	fmt.Fprintln(bw, "</svg>")
+
+	return bw.Flush()
+}
+
+func escapeAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
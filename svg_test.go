@@ -0,0 +1,130 @@
+package dyntree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteSVG(T *testing.T) {
+	t := NewTree()
+	t.Add(&Person{1, Vec3{0, 0, 0}})
+	t.Add(&Person{1, Vec3{0, 10, 100}})
+	t.Add(&Person{1, Vec3{0, 20, 50}})
+	s := t.Stats()
+
+	for _, plane := range []Plane{XY, XZ, YZ} {
+		buf := bytes.Buffer{}
+		if err := t.WriteSVG(&buf, SVGOptions{Plane: plane, Width: 500}); err != nil {
+			T.Fatal(err)
+		}
+
+		rects, circles, titles := 0, 0, 0
+		var root struct{ Width, Height float64 }
+
+		dec := xml.NewDecoder(&buf)
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				T.Fatal("Malformed SVG", err)
+			}
+
+			if el, ok := tok.(xml.StartElement); ok {
+				switch el.Name.Local {
+				case "svg":
+					for _, a := range el.Attr {
+						switch a.Name.Local {
+						case "width":
+							root.Width, _ = strconv.ParseFloat(a.Value, 64)
+						case "height":
+							root.Height, _ = strconv.ParseFloat(a.Value, 64)
+						}
+					}
+				case "rect":
+					rects++
+				case "circle":
+					circles++
+				case "title":
+					titles++
+				}
+			}
+		}
+
+		// The first rect is the background
+		if rects != s.Nodes+1 || circles != s.Entities || titles != s.Nodes+s.Entities {
+			T.Fatal("Wrong element counts", plane, rects, circles, titles)
+		}
+
+		// Every plane sees a different aspect ratio of the same tree
+		if root.Width != 500 {
+			T.Fatal("Width not honoured", plane, root.Width)
+		}
+		min, max := t.Root().Box.Min, t.Root().Box.Max
+		x1, y1 := plane.Project(min)
+		x2, y2 := plane.Project(max)
+		if want := 500 * (y2 - y1) / (x2 - x1); math.Abs(root.Height-want) > 0.01 {
+			T.Fatal("Height doesn't follow the aspect ratio", plane, root.Height, want)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := t.WriteSVG(&buf, SVGOptions{Width: 100, Height: 100, DepthColors: []string{"red"}}); err != nil {
+		T.Fatal(err)
+	}
+	if strings.Count(buf.String(), `stroke="red"`) != s.Nodes {
+		T.Fatal("Custom depth colours not used")
+	}
+
+	if err := t.WriteSVG(failingWriter{}, SVGOptions{}); err == nil {
+		T.Fatal("Write error wasn't returned")
+	}
+
+	if err := t.Image(filepath.Join(T.TempDir(), "missing", "tree.bmp")); err == nil {
+		T.Fatal("Image didn't report failing to create its file")
+	}
+}
+
+func TestWriteSVGEscapesColors(T *testing.T) {
+	t := NewTree()
+	t.Add(&Person{1, Vec3{0, 0, 0}})
+	t.Add(&Person{1, Vec3{10, 10, 0}})
+
+	hostile := `red"/><script>alert(1)</script><rect fill="`
+	buf := bytes.Buffer{}
+	if err := t.WriteSVG(&buf, SVGOptions{Background: hostile, EntityColor: hostile, DepthColors: []string{hostile, "<"}}); err != nil {
+		T.Fatal(err)
+	}
+
+	// Every colour has to come back out of its attribute intact, without any elements sneaking in around it
+	dec := xml.NewDecoder(&buf)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			T.Fatal("Invalid SVG", err)
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if se.Name.Local == "script" {
+			T.Fatal("Colour injected an element")
+		}
+		for _, a := range se.Attr {
+			if (a.Name.Local == "fill" || a.Name.Local == "stroke") && a.Value != "none" && a.Value != hostile && a.Value != "<" {
+				T.Fatal("Colour mangled", a.Value)
+			}
+		}
+	}
+}
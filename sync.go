@@ -0,0 +1,263 @@
+package dyntree
+
+import (
+	"image"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncTree guards a Tree with a reader/writer lock. Queries hold a read lock and can run alongside each other,
+// anything that mutates the tree holds the write lock and gets exclusive access.
+//
+// Callbacks passed to queries run while the read lock is held, so they must not mutate the tree themselves.
+type SyncTree struct {
+	mu   sync.RWMutex
+	tree *Tree
+	// id orders the locks of two trees taken together, so concurrent Intersects can't deadlock
+	id uint64
+}
+
+var syncTreeIDs uint64
+
+func NewSyncTree(opts ...Option) *SyncTree {
+	return WrapTree(NewTree(opts...))
+}
+
+// WrapTree guards an existing tree, which must not be used directly afterwards
+func WrapTree(t *Tree) *SyncTree {
+	return &SyncTree{tree: t, id: atomic.AddUint64(&syncTreeIDs, 1)}
+}
+
+// Read runs fn with shared access to the underlying tree, for queries SyncTree doesn't wrap
+func (s *SyncTree) Read(fn func(t *Tree)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.tree)
+}
+
+// Write runs fn with exclusive access to the underlying tree, which is also where entities should be moved
+// before calling QueueForOptimize so readers never observe a half-updated position
+func (s *SyncTree) Write(fn func(t *Tree)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.tree)
+}
+
+func (s *SyncTree) Add(e Entity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.Add(e)
+}
+
+func (s *SyncTree) Remove(e Entity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.Remove(e)
+}
+
+func (s *SyncTree) TryAdd(e Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.TryAdd(e)
+}
+
+func (s *SyncTree) TryRemove(e Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.TryRemove(e)
+}
+
+func (s *SyncTree) TryQueueForOptimize(e Entity) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.TryQueueForOptimize(e)
+}
+
+func (s *SyncTree) QueueForOptimize(e Entity) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.QueueForOptimize(e)
+}
+
+func (s *SyncTree) Optimize() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.Optimize()
+}
+
+func (s *SyncTree) OptimizeFor(budget time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.OptimizeFor(budget)
+}
+
+func (s *SyncTree) Traverse(test HitTest) []Entity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Traverse(test)
+}
+
+func (s *SyncTree) ConcurrentTraverse(test HitTest) []Entity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.ConcurrentTraverse(test)
+}
+
+func (s *SyncTree) ParallelTraverse(test HitTest, opts ParallelOptions) []Entity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.ParallelTraverse(test, opts)
+}
+
+func (s *SyncTree) Visit(test HitTest, visit func(Entity) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.tree.Visit(test, visit)
+}
+
+func (s *SyncTree) RayCast(origin, dir Vec3, maxDist float64) []RayHit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.RayCast(origin, dir, maxDist)
+}
+
+func (s *SyncTree) RayCastFirst(origin, dir Vec3, maxDist float64) (RayHit, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.RayCastFirst(origin, dir, maxDist)
+}
+
+func (s *SyncTree) Nearest(p Vec3) (Entity, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Nearest(p)
+}
+
+func (s *SyncTree) KNearest(p Vec3, k int) []Entity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.KNearest(p, k)
+}
+
+func (s *SyncTree) OverlappingPairs(fn func(a, b Entity)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.tree.OverlappingPairs(fn)
+}
+
+func (s *SyncTree) TryUpdate(moved []Entity) (UpdateStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.TryUpdate(moved)
+}
+
+func (s *SyncTree) Update(moved []Entity) UpdateStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Update(moved)
+}
+
+func (s *SyncTree) Rebuild() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.Rebuild()
+}
+
+func (s *SyncTree) RebuildIfDegraded(threshold float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.RebuildIfDegraded(threshold)
+}
+
+// Intersect is Tree.Intersect holding the read locks of both trees, always taken in the same order
+func (s *SyncTree) Intersect(other *SyncTree, fn func(a, b Entity) bool) {
+	first, second := s, other
+	if second.id < first.id {
+		first, second = second, first
+	}
+
+	first.mu.RLock()
+	defer first.mu.RUnlock()
+
+	// Taking the same read lock twice could deadlock behind a waiting writer
+	if second != first {
+		second.mu.RLock()
+		defer second.mu.RUnlock()
+	}
+
+	s.tree.Intersect(other.tree, fn)
+}
+
+func (s *SyncTree) Entities() []Entity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Entities()
+}
+
+func (s *SyncTree) SAHCost() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.SAHCost()
+}
+
+func (s *SyncTree) PendingOptimizations() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.PendingOptimizations()
+}
+
+func (s *SyncTree) Options() TreeOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Options()
+}
+
+func (s *SyncTree) Stats() TreeStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Stats()
+}
+
+func (s *SyncTree) Validate() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Validate()
+}
+
+func (s *SyncTree) WriteSnapshot(w io.Writer, codec EntityCodec) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.WriteSnapshot(w, codec)
+}
+
+func (s *SyncTree) ExportJSON(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.ExportJSON(w)
+}
+
+func (s *SyncTree) WriteDOT(w io.Writer, opts DOTOptions) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.WriteDOT(w, opts)
+}
+
+func (s *SyncTree) WriteSVG(w io.Writer, opts SVGOptions) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.WriteSVG(w, opts)
+}
+
+func (s *SyncTree) Render(vp Viewport) (*image.RGBA, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Render(vp)
+}
+
+func (s *SyncTree) WritePNG(w io.Writer, vp Viewport) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.WritePNG(w, vp)
+}
@@ -0,0 +1,90 @@
+package dyntree
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// These are mostly useful under the race detector: go test -race -run Sync
+
+func TestSyncTreeMixedWorkload(T *testing.T) {
+	s := NewSyncTree()
+	other := NewSyncTree()
+
+	// Keep a floor of entities that are never removed so the tree can't collapse to nothing
+	for i := 0; i < 100; i++ {
+		s.Add(&Person{size: 1, position: Vec3{float64(i * 10), 0, 0}})
+		other.Add(&Person{size: 1, position: Vec3{float64(i * 10), 0, 0}})
+	}
+
+	everything := func(BoundingBox) bool { return true }
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			added := []*Person{}
+
+			for i := 0; i < 500; i++ {
+				switch r.Intn(4) {
+				case 0:
+					p := &Person{size: 1, position: Vec3{float64(r.Intn(1000)), float64(r.Intn(1000)), float64(r.Intn(1000))}}
+					s.Add(p)
+					added = append(added, p)
+				case 1:
+					if len(added) > 0 {
+						s.Remove(added[len(added)-1])
+						added = added[:len(added)-1]
+					}
+				case 2:
+					if len(added) > 0 {
+						p := added[r.Intn(len(added))]
+						s.Write(func(t *Tree) {
+							p.position = Vec3{float64(r.Intn(1000)), float64(r.Intn(1000)), float64(r.Intn(1000))}
+							t.QueueForOptimize(p)
+						})
+					}
+				case 3:
+					s.Optimize()
+					other.Add(&Person{size: 1, position: Vec3{float64(r.Intn(1000)), 0, 0}})
+				}
+			}
+		}(int64(w))
+	}
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				if len(s.Traverse(everything)) < 100 {
+					T.Error("Traverse lost entities")
+				}
+				if len(s.ConcurrentTraverse(everything)) < 100 {
+					T.Error("ConcurrentTraverse lost entities")
+				}
+				s.RayCast(Vec3{}, Vec3{1, 1, 1}, 0)
+				s.KNearest(Vec3{500, 500, 500}, 5)
+				s.Visit(everything, func(Entity) bool { return true })
+
+				// Both orders at once, which would deadlock behind the writers if the locks weren't ordered
+				pairs := 0
+				s.Intersect(other, func(a, b Entity) bool {
+					pairs++
+					return true
+				})
+				if pairs < 100 {
+					T.Error("Intersect lost pairs", pairs)
+				}
+				other.Intersect(s, func(a, b Entity) bool { return true })
+				s.Intersect(s, func(a, b Entity) bool { return true })
+			}
+		}()
+	}
+
+	wg.Wait()
+}
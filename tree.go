@@ -1,13 +1,15 @@
 package dyntree
 
 import (
+	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
 	"math"
+	"math/bits"
 	"os"
+	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
 
@@ -115,6 +117,26 @@ type Vec3 struct {
 	X, Y, Z float64
 }
 
+func (v Vec3) Add(v2 Vec3) Vec3 {
+	return Vec3{v.X + v2.X, v.Y + v2.Y, v.Z + v2.Z}
+}
+
+func (v Vec3) Sub(v2 Vec3) Vec3 {
+	return Vec3{v.X - v2.X, v.Y - v2.Y, v.Z - v2.Z}
+}
+
+func (v Vec3) Scale(s float64) Vec3 {
+	return Vec3{v.X * s, v.Y * s, v.Z * s}
+}
+
+func (v Vec3) Dot(v2 Vec3) float64 {
+	return v.X*v2.X + v.Y*v2.Y + v.Z*v2.Z
+}
+
+func (v Vec3) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
 type BoundingBox struct {
 	Min, Max Vec3
 }
@@ -141,10 +163,35 @@ func (b BoundingBox) SurfaceArea() float64 {
 	return 2.0 * (xSize*ySize + xSize*zSize + ySize*zSize)
 }
 
+// Contains reports whether b2 lies entirely within b
+func (b BoundingBox) Contains(b2 BoundingBox) bool {
+	return b2.Min.X >= b.Min.X && b2.Max.X <= b.Max.X &&
+		b2.Min.Y >= b.Min.Y && b2.Max.Y <= b.Max.Y &&
+		b2.Min.Z >= b.Min.Z && b2.Max.Z <= b.Max.Z
+}
+
+// Grow returns b enlarged by m on every side
+func (b BoundingBox) Grow(m float64) BoundingBox {
+	return BoundingBox{
+		Min: Vec3{b.Min.X - m, b.Min.Y - m, b.Min.Z - m},
+		Max: Vec3{b.Max.X + m, b.Max.Y + m, b.Max.Z + m},
+	}
+}
+
+// Distance returns how far p is from the closest point of b, or 0 if p is inside of it
+func (b BoundingBox) Distance(p Vec3) float64 {
+	d := Vec3{
+		math.Max(0, math.Max(b.Min.X-p.X, p.X-b.Max.X)),
+		math.Max(0, math.Max(b.Min.Y-p.Y, p.Y-b.Max.Y)),
+		math.Max(0, math.Max(b.Min.Z-p.Z, p.Z-b.Max.Z)),
+	}
+	return d.Length()
+}
+
 func EntitiesSurfaceArea(ea []Entity, start, ct int) float64 {
-	box := BoxFromEntity(ea[0])
+	box := BoxFromEntity(ea[start])
 
-	for i := start + 1; i < ct; i++ {
+	for i := start + 1; i < start+ct; i++ {
 		box = box.Expand(BoxFromEntity(ea[i]))
 	}
 
@@ -205,7 +252,7 @@ func (s *SplitAxisOpt) LeftEndIndex() int {
 	return s.SplitIndex - 1
 }
 func (s *SplitAxisOpt) LeftItemCount() int {
-	return s.LeftEndIndex()
+	return s.LeftEndIndex() - s.LeftStartIndex() + 1
 }
 func (s *SplitAxisOpt) RightStartIndex() int {
 	return s.SplitIndex
@@ -214,9 +261,9 @@ func (s *SplitAxisOpt) RightEndIndex() int {
 	return len(s.Items) - 1
 }
 func (s *SplitAxisOpt) RightItemCount() int {
-	return s.RightEndIndex() - s.RightStartIndex()
+	return s.RightEndIndex() - s.RightStartIndex() + 1
 }
-func (s *SplitAxisOpt) TryImproveAxis(a Axis) {
+func (s *SplitAxisOpt) SortAxis(a Axis) {
 	switch a {
 	case X:
 		sort.SliceStable(s.Items, func(i, j int) bool { return s.Items[i].Position().X < s.Items[j].Position().X })
@@ -225,6 +272,9 @@ func (s *SplitAxisOpt) TryImproveAxis(a Axis) {
 	case Z:
 		sort.SliceStable(s.Items, func(i, j int) bool { return s.Items[i].Position().Z < s.Items[j].Position().Z })
 	}
+}
+func (s *SplitAxisOpt) TryImproveAxis(a Axis) {
+	s.SortAxis(a)
 
 	left := EntitiesSurfaceArea(s.Items, s.LeftStartIndex(), s.LeftItemCount())
 	right := EntitiesSurfaceArea(s.Items, s.RightStartIndex(), s.RightItemCount())
@@ -242,6 +292,13 @@ type Entity interface {
 	Radius() float64
 }
 
+// MovingEntity is an Entity that reports where it's heading, so the tree can stretch its volume
+// to cover wherever it will be over the tree's velocity horizon
+type MovingEntity interface {
+	Entity
+	Velocity() Vec3
+}
+
 type Node struct {
 	Box BoundingBox
 
@@ -394,6 +451,19 @@ type Tree struct {
 
 	maxDepth  int
 	maxLeaves int
+	margin    float64
+	horizon   float64
+
+	pushdownFactor    float64
+	rotationThreshold float64
+
+	buildWorkers  int
+	treeletPasses int
+
+	// referenceCost is the SAH cost of a fresh build holding referenceEntities entities, which RebuildIfDegraded
+	// compares against
+	referenceCost     float64
+	referenceEntities int
 
 	IsCreated bool
 
@@ -401,15 +471,43 @@ type Tree struct {
 	nodes      []*Node
 	refitQueue []*Node
 
+	// refitLevels holds the branches OptimizeFor still has to refit and price, by depth
+	refitLevels  [][]*Node
+	refitPending map[*Node]bool
+
+	// rotationQueue holds the branches OptimizeFor hasn't gotten to yet, ordered by how much a rotation would save
+	rotationQueue   rotationQueue
+	rotationPending map[*Node]bool
+
 	unusedBucketIndicies []int
 	unusedNodeIndicies   []int
 
 	Buckets [][]Entity
 }
 
-func NewTree() *Tree {
+func NewTree(opts ...Option) *Tree {
+	o := DefaultTreeOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	t, err := NewTreeWithOptions(o)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func newTree(o TreeOptions) *Tree {
 	t := &Tree{
-		maxLeaves: 1,
+		maxLeaves:         o.MaxLeaves,
+		margin:            o.Margin,
+		horizon:           o.VelocityHorizon,
+		pushdownFactor:    o.PushdownFactor,
+		rotationThreshold: o.RotationThreshold,
+		buildWorkers:      o.BuildWorkers,
+		treeletPasses:     o.TreeletPasses,
 
 		leafs:      make(map[Entity]*Node),
 		nodes:      make([]*Node, 0),
@@ -423,6 +521,10 @@ func NewTree() *Tree {
 		IsCreated: true,
 	}
 
+	if t.maxLeaves == 0 {
+		t.maxLeaves = DefaultMaxLeaves
+	}
+
 	t.rootNode = t.CreateNode(-1)
 
 	return t
@@ -466,6 +568,11 @@ func (t *Tree) FreeNode(n *Node) {
 	n.Right = nil
 	n.BucketIndex = -1
 	n.Depth = 0
+	n.State = 0
+
+	// The node may still be waiting in OptimizeFor's queues, where it would stand in for whatever reuses it next
+	delete(t.refitPending, n)
+	delete(t.rotationPending, n)
 
 	t.unusedNodeIndicies = append(t.unusedNodeIndicies, n.NodeIndex)
 }
@@ -488,64 +595,201 @@ func (t *Tree) GetLeaf(e Entity) (n *Node, ok bool) {
 	return
 }
 
+// FatBox returns the volume the tree stores for e, which is its box swept along its expected displacement
+// over the velocity horizon and then enlarged by the tree's margin
+func (t *Tree) FatBox(e Entity) BoundingBox {
+	box := BoxFromEntity(e)
+
+	if m, ok := e.(MovingEntity); ok && t.horizon > 0 {
+		d := m.Velocity().Scale(t.horizon)
+		box = box.Expand(BoundingBox{box.Min.Add(d), box.Max.Add(d)})
+	}
+
+	return box.Grow(t.margin)
+}
+
+// looseLeaves reports whether a leaf's volume can be larger than the entities inside of it, in which case
+// entities have to be tested on their own to give the same results as a tight tree
+func (t *Tree) looseLeaves() bool {
+	return t.margin > 0 || t.horizon > 0 || t.maxLeaves > 1
+}
+
+func (t *Tree) appendLeafHits(hits []Entity, n *Node, test HitTest) []Entity {
+	b := t.Buckets[n.BucketIndex-1]
+
+	if !t.looseLeaves() {
+		return append(hits, b...)
+	}
+
+	for _, e := range b {
+		if test(BoxFromEntity(e)) {
+			hits = append(hits, e)
+		}
+	}
+
+	return hits
+}
+
+// stillFits reports whether e can stay in leaf n without the tree being touched. Loose leaves have their entities
+// tested one by one, so anything inside the stored volume will do until it moves further. A tight leaf is returned
+// whole by queries, so its volume has to match exactly or e would be found where it no longer is.
+func (t *Tree) stillFits(n *Node, e Entity) bool {
+	if t.looseLeaves() {
+		return n.Box.Contains(BoxFromEntity(e))
+	}
+	return n.Box.Equals(BoxFromEntity(e))
+}
+
 func (t *Tree) QueueForOptimize(e Entity) bool {
+	ok, err := t.TryQueueForOptimize(e)
+	if err != nil {
+		panic(err)
+	}
+
+	return ok
+}
+
+// TryQueueForOptimize is QueueForOptimize returning ErrCorruptTree instead of panicking if the tree turns out to be
+// inconsistent along the way
+func (t *Tree) TryQueueForOptimize(e Entity) (queued bool, err error) {
+	defer recoverCorrupt(&err)
+
 	n, ok := t.GetLeaf(e)
 
 	if !ok {
-		return false
+		return false, nil
 	}
 
-	if !n.IsLeaf() {
-		log.Errorln("Dangling leaf", n)
+	if !n.IsValidLeafNode() {
+		return false, fmt.Errorf("%w: entity is mapped to node %d which isn't a leaf", ErrCorruptTree, n.NodeIndex)
+	}
+
+	if t.stillFits(n, e) {
+		return true, nil
+	}
+
+	bn, ok, err := t.findBetterNode(n, e)
+	if err != nil {
+		return false, err
 	}
 
-	if bn, ok := t.TryFindBetterNode(n, e); ok {
+	if ok {
 		t.MoveItemBetweenNodes(n, bn, e)
 	} else if t.RefitVolume(n) && n.Parent != nil {
 		t.refitQueue = append(t.refitQueue, n)
 	}
 
-	return true
+	return true, nil
 }
 
-func (t *Tree) ConcurrentTraverseNode(cur *Node, test HitTest) (hits []Entity) {
-	if !cur.IsValid() {
-		return
+// ParallelOptions tunes how ParallelTraverse splits a query across goroutines
+type ParallelOptions struct {
+	// Workers is the size of the worker pool, defaulting to GOMAXPROCS
+	Workers int
+	// SplitDepth is how many levels below the starting node are walked serially before the remaining
+	// subtrees are handed out to the workers, defaulting to roughly four subtrees per worker
+	SplitDepth int
+}
+
+func (t *Tree) ConcurrentTraverseNode(cur *Node, test HitTest) []Entity {
+	return t.ParallelTraverseNode(cur, test, ParallelOptions{})
+}
+
+func (t *Tree) ParallelTraverseNode(cur *Node, test HitTest, opts ParallelOptions) (hits []Entity) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
 	}
 
-	if test(cur.Box) {
-		if cur.BucketIndex != -1 {
-			hits = append(hits, t.Buckets[cur.BucketIndex-1]...)
+	splitDepth := opts.SplitDepth
+	if splitDepth <= 0 {
+		splitDepth = bits.Len(uint(workers)) + 2
+	}
+
+	type frame struct {
+		node  *Node
+		depth int
+	}
+
+	// Walk the top of the tree ourselves, anything still left to visit at the split depth becomes a job for the pool
+	var subtrees []*Node
+	stack := []frame{{cur, 0}}
+
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !f.node.IsValid() || !test(f.node.Box) {
+			continue
 		}
 
-		lock := sync.Mutex{}
-		wg := sync.WaitGroup{}
-		if cur.Left != nil {
-			wg.Add(1)
-			go func() {
-				new := t.ConcurrentTraverseNode(cur.Left, test)
-				lock.Lock()
-				hits = append(hits, new...)
-				lock.Unlock()
-				wg.Done()
-			}()
+		if f.node.IsLeaf() {
+			hits = t.appendLeafHits(hits, f.node, test)
+			continue
 		}
 
-		if cur.Right != nil {
-			wg.Add(1)
-			go func() {
-				new := t.ConcurrentTraverseNode(cur.Right, test)
-				lock.Lock()
-				hits = append(hits, new...)
-				lock.Unlock()
-				wg.Done()
-			}()
+		if f.depth+1 >= splitDepth {
+			subtrees = append(subtrees, f.node.Right, f.node.Left)
+			continue
 		}
 
-		wg.Wait()
+		stack = append(stack, frame{f.node.Right, f.depth + 1}, frame{f.node.Left, f.depth + 1})
 	}
 
-	return
+	if workers > len(subtrees) {
+		workers = len(subtrees)
+	}
+
+	if workers <= 1 {
+		for _, n := range subtrees {
+			t.VisitNode(n, test, func(e Entity) bool {
+				hits = append(hits, e)
+				return true
+			})
+		}
+		return
+	}
+
+	// Every worker owns its buffer, so nothing needs to be locked until they're merged at the end
+	buffers := make([][]Entity, workers)
+	next := int64(-1)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			buf := buffers[w]
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= int64(len(subtrees)) {
+					break
+				}
+
+				t.VisitNode(subtrees[i], test, func(e Entity) bool {
+					buf = append(buf, e)
+					return true
+				})
+			}
+			buffers[w] = buf
+		}(w)
+	}
+
+	wg.Wait()
+
+	total := len(hits)
+	for _, buf := range buffers {
+		total += len(buf)
+	}
+
+	merged := make([]Entity, 0, total)
+	merged = append(merged, hits...)
+	for _, buf := range buffers {
+		merged = append(merged, buf...)
+	}
+
+	return merged
 }
 
 func (t *Tree) TraverseNode(cur *Node, test HitTest) (hits []Entity) {
@@ -555,7 +799,7 @@ func (t *Tree) TraverseNode(cur *Node, test HitTest) (hits []Entity) {
 
 	if test(cur.Box) {
 		if cur.BucketIndex != -1 {
-			hits = append(hits, t.Buckets[cur.BucketIndex-1]...)
+			hits = t.appendLeafHits(hits, cur, test)
 		}
 
 		if cur.Left != nil {
@@ -574,19 +818,69 @@ func (t *Tree) Traverse(test HitTest) []Entity {
 	return t.TraverseNode(t.rootNode, test)
 }
 
+// Visit streams every entity in a leaf passing test to visit without allocating a result slice.
+// Returning false from visit stops the traversal.
+func (t *Tree) Visit(test HitTest, visit func(Entity) bool) {
+	t.VisitNode(t.rootNode, test, visit)
+}
+
+func (t *Tree) VisitNode(cur *Node, test HitTest, visit func(Entity) bool) {
+	// Deep enough for any reasonably balanced tree, anything past it spills onto the heap
+	var buf [64]*Node
+	stack := append(buf[:0], cur)
+
+	for len(stack) > 0 {
+		cur, stack = stack[len(stack)-1], stack[:len(stack)-1]
+
+		if !cur.IsValid() || !test(cur.Box) {
+			continue
+		}
+
+		if cur.IsLeaf() {
+			loose := t.looseLeaves()
+			for _, e := range t.Buckets[cur.BucketIndex-1] {
+				if loose && !test(BoxFromEntity(e)) {
+					continue
+				}
+				if !visit(e) {
+					return
+				}
+			}
+			continue
+		}
+
+		stack = append(stack, cur.Right, cur.Left)
+	}
+}
+
 func (t *Tree) ConcurrentTraverse(test HitTest) []Entity {
 	return t.ConcurrentTraverseNode(t.rootNode, test)
 }
 
-func (t *Tree) TryFindBetterNode(cur *Node, e Entity) (bn *Node, ok bool) {
-	box := BoxFromEntity(e)
+func (t *Tree) ParallelTraverse(test HitTest, opts ParallelOptions) []Entity {
+	return t.ParallelTraverseNode(t.rootNode, test, opts)
+}
+
+// TryFindBetterNode looks for a node e would be better off in than its current leaf cur. A corrupt tree is only
+// logged and reported as there being no better node, TryQueueForOptimize and TryUpdate return ErrCorruptTree instead.
+func (t *Tree) TryFindBetterNode(cur *Node, e Entity) (*Node, bool) {
+	bn, ok, err := t.findBetterNode(cur, e)
+	if err != nil {
+		log.Errorln(err)
+		return nil, false
+	}
+	return bn, ok
+}
+
+func (t *Tree) findBetterNode(cur *Node, e Entity) (bn *Node, ok bool, err error) {
+	box := t.FatBox(e)
 	sa := box.SurfaceArea()
 
 	bn = t.rootNode
 
 	for bn.BucketIndex == -1 {
 		if !bn.IsValid() || bn.Left != nil && !bn.Left.IsValid() || bn.Right != nil && !bn.Right.IsValid() {
-			panic("Invalid node")
+			return nil, false, fmt.Errorf("%w: invalid node %d on the way down", ErrCorruptTree, bn.NodeIndex)
 		}
 
 		left := bn.Left
@@ -596,26 +890,25 @@ func (t *Tree) TryFindBetterNode(cur *Node, e Entity) (bn *Node, ok bool) {
 		mergedSa := left.Box.Expand(right.Box).SurfaceArea() + sa
 
 		// Doing a merge-and-pushdown can be expensive, so we only do it if it's notably better
-		if mergedSa < math.Min(leftSa, rightSa)*0.3 {
+		if mergedSa < math.Min(leftSa, rightSa)*t.pushdownFactor {
 			break
 		}
 
-		switch {
-		case leftSa < rightSa:
+		if leftSa < rightSa {
 			bn = left
-		case leftSa > rightSa:
+		} else {
 			bn = right
 		}
 	}
 
 	if bn.Equals(t.rootNode) || bn.Equals(cur) {
-		return nil, false
+		return nil, false, nil
 	}
 
-	if bn.Equals(cur.Parent) && cur.IsLeaf() {
+	if bn.Equals(cur.Parent) && cur.IsLeaf() && t.ItemCount(cur) <= 1 {
 		// This scenario doesn't work because the source is a leaf and the parent already has two nodes,
 		// so moving it up would create a dangling leaf in the vacated spot.
-		// todo: might need to allow this where max leaf count > 1 and parent items bucket is not at max capacity.
+		// It's fine when the leaf holds other items as well, since it won't be vacated.
 		/*
 		     x           x
 		    / \         / \
@@ -623,24 +916,24 @@ func (t *Tree) TryFindBetterNode(cur *Node, e Entity) (bn *Node, ok bool) {
 		      / \	       /
 		     ?   s       ?
 		*/
-		return nil, false
+		return nil, false, nil
 	}
 
-	return bn, true
+	return bn, true, nil
 }
 
 func (t *Tree) RemoveNode(n *Node) *Node {
 	p := n.Parent
 	gp := p.Parent
+	depth := p.Depth
 
 	keep := n.GetSibling()
+	keep.Parent = gp
 
 	if gp == nil {
-		if !keep.IsLeaf() {
-			t.rootNode = keep
-		}
+		// The sibling takes the place of the root, even if that leaves us with a single leaf
+		t.rootNode = keep
 	} else {
-		keep.Parent = gp
 		if gp.Left == p {
 			gp.Left = keep
 		} else {
@@ -652,9 +945,7 @@ func (t *Tree) RemoveNode(n *Node) *Node {
 	t.FreeNode(n)
 	t.FreeNode(p)
 
-	if keep.BucketIndex != -1 {
-		t.SetDepth(keep, keep.Depth+1)
-	}
+	t.SetDepth(keep, depth)
 
 	if keep.Parent != nil {
 		t.ChildRefit(keep.Parent, true)
@@ -670,11 +961,7 @@ func (t *Tree) MoveItemBetweenNodes(from, to *Node, e Entity) {
 
 func (t *Tree) RemoveItemFromNode(n *Node, e Entity) {
 	if !n.IsLeaf() {
-		panic("Remove on non leaf")
-	}
-
-	if !n.HasParent() {
-		panic("attempt to collapse node with no parent")
+		panic(corruption("Remove on non leaf"))
 	}
 
 	t.UnmapLeaf(e)
@@ -684,18 +971,20 @@ func (t *Tree) RemoveItemFromNode(n *Node, e Entity) {
 		if entity == e {
 			found = true
 			t.Buckets[n.BucketIndex-1] = append(t.Buckets[n.BucketIndex-1][:i], t.Buckets[n.BucketIndex-1][i+1:]...)
+			break
 		}
 	}
 	if !found {
-		panic("Entity not found in node")
+		panic(corruption("Entity not found in node"))
 	}
 
 	if !t.IsEmpty(n) {
 		t.RefitVolume(n)
+	} else if n.HasParent() {
+		t.RemoveNode(n)
 	} else {
-		if n.HasParent() {
-			t.RemoveNode(n)
-		}
+		// The root leaf is all that's left, so the tree is empty again
+		n.Box = BoundingBox{}
 	}
 }
 
@@ -733,64 +1022,79 @@ func (t *Tree) ItemCount(n *Node) int {
 }
 
 func (t *Tree) Optimize() {
-	if t.maxLeaves != 1 {
+	if t.PendingOptimizations() == 0 {
 		return
 	}
 
-	if len(t.refitQueue) == 0 {
-		return
+	nodes := t.pendingRefits()
+	for _, item := range t.rotationQueue {
+		nodes = append(nodes, item.node)
 	}
 
-	sort.SliceStable(t.refitQueue, func(i, j int) bool { return t.refitQueue[i].Depth < t.refitQueue[j].Depth })
+	t.RefitAndRotate(nodes)
+	t.refitQueue = make([]*Node, 0)
+	t.refitLevels = nil
+	t.refitPending = nil
+	t.rotationQueue = nil
+	t.rotationPending = nil
+}
 
-	curDepth := t.refitQueue[0].Depth
+// RefitAndRotate refits the given nodes and all of their ancestors from the bottom up, trying a rotation at each
+// branch along the way. Ancestors shared by several of the nodes are only ever visited once.
+func (t *Tree) RefitAndRotate(nodes []*Node) {
+	t.refitUp(nodes, t.TryRotate)
+}
 
-	i := 0
+// refitUp refits the given nodes and their ancestors from the bottom up like RefitAndRotate, handing each branch
+// to visit once its children are final
+func (t *Tree) refitUp(nodes []*Node, visit func(n *Node)) {
+	levels := make([][]*Node, 0)
 
-	for curDepth > 0 {
-		for ; i < len(t.refitQueue); i++ {
-			n := t.refitQueue[i]
+	queue := func(n *Node) {
+		if n.State&OPTIMIZATIONQUEUED != 0 {
+			return
+		}
+		n.State |= OPTIMIZATIONQUEUED
 
-			if i == 0 {
-				curDepth = n.Depth
-			}
+		for len(levels) <= n.Depth {
+			levels = append(levels, nil)
+		}
+		levels[n.Depth] = append(levels[n.Depth], n)
+	}
 
-			if n.Depth != curDepth {
-				break
-			}
+	for _, n := range nodes {
+		queue(n)
+	}
+
+	// Parents are always exactly one level above their children, so by the time we reach a level
+	// everything below it that could have queued a node there has already been processed
+	for depth := len(levels) - 1; depth >= 0; depth-- {
+		for _, n := range levels[depth] {
+			n.State &^= OPTIMIZATIONQUEUED
 
 			if !n.IsValid() {
 				continue
 			}
 
-			n.State &= OPTIMIZATIONQUEUED
-
-			t.TryRotate(n)
-
-			if !n.HasParent() {
-				continue
+			if n.IsLeaf() {
+				t.ComputeVolume(n)
+			} else {
+				t.ChildRefit(n, false)
+				visit(n)
 			}
 
-			if n.Parent.State&OPTIMIZATIONQUEUED != 0 {
-				continue
+			if n.HasParent() {
+				queue(n.Parent)
 			}
-
-			n.Parent.State |= OPTIMIZATIONQUEUED
-
-			t.refitQueue = append(t.refitQueue, n.Parent)
 		}
-		curDepth--
 	}
-	t.refitQueue = make([]*Node, 0)
 }
 
-func (t *Tree) TryRotate(n *Node) {
-	if n.IsLeaf() && n.Parent != nil {
-		return
-	}
-
-	sa := n.Left.Box.SurfaceArea() + n.Right.Box.SurfaceArea()
-	best := &RotOpt{ROTNONE, math.MaxFloat64}
+// BestRotation returns the rotation that would leave n's children with the least surface area,
+// along with the surface area they have now
+func (n *Node) BestRotation() (best RotOpt, sa float64) {
+	sa = n.Left.Box.SurfaceArea() + n.Right.Box.SurfaceArea()
+	best = RotOpt{ROTNONE, math.MaxFloat64}
 
 	best.FindBestRotation(n, LEFTRIGHTLEFT, sa)
 	best.FindBestRotation(n, LEFTRIGHTRIGHT, sa)
@@ -799,12 +1103,52 @@ func (t *Tree) TryRotate(n *Node) {
 	best.FindBestRotation(n, LEFTLEFTRIGHTLEFT, sa)
 	best.FindBestRotation(n, LEFTLEFTRIGHTRIGHT, sa)
 
-	if best.Rot != ROTNONE {
-		diff := (sa - best.SA) / sa
-		if diff <= 0 {
-			return
-		}
+	return
+}
+
+// acceptedRotation returns the rotation TryRotate applies to n and how much surface area it saves between n's
+// children, if there's one worth applying. Optimize and OptimizeFor both go through here so they agree on which
+// rotations to take.
+func (t *Tree) acceptedRotation(n *Node) (best RotOpt, gain float64, ok bool) {
+	if !n.IsValidBranchNode() {
+		return
+	}
+
+	best, sa := n.BestRotation()
+	if best.Rot == ROTNONE || sa <= 0 {
+		return
+	}
+
+	gain = sa - best.SA
+	if gain/sa <= t.rotationThreshold {
+		return
+	}
+
+	// Swapping a child with a grandchild counts the child as gone, but it's still there below the other child which
+	// grows to hold it. Taken over and over that pushes big subtrees down into long chains, so only rotations that
+	// shrink the tree as a whole are accepted.
+	var down, up *Node
+	switch best.Rot {
+	case LEFTRIGHTLEFT:
+		down, up = n.Left, n.Right.Left
+	case LEFTRIGHTRIGHT:
+		down, up = n.Left, n.Right.Right
+	case RIGHTLEFTLEFT:
+		down, up = n.Right, n.Left.Left
+	case RIGHTLEFTRIGHT:
+		down, up = n.Right, n.Left.Right
+	}
+	if down != nil && gain-down.Box.SurfaceArea()+up.Box.SurfaceArea() <= 0 {
+		return
+	}
+
+	return best, gain, true
+}
 
+func (t *Tree) TryRotate(n *Node) {
+	best, _, ok := t.acceptedRotation(n)
+
+	if ok {
 		var swap *Node
 
 		switch best.Rot {
@@ -895,12 +1239,17 @@ func (t *Tree) SplitNode(n *Node) {
 	split.TryImproveAxis(Y)
 	split.TryImproveAxis(Z)
 
+	// The items are left sorted along the last axis we tried, which isn't necessarily the best one
+	if split.Axis != Z {
+		split.SortAxis(split.Axis)
+	}
+
 	n.Left = t.CreateNodeFromSplit(n, split, LEFT, n.Depth+1, n.BucketIndex)
 	n.Right = t.CreateNodeFromSplit(n, split, RIGHT, n.Depth+1, -1)
 	n.BucketIndex = -1
 
 	if !(!n.IsLeaf() && n.Left.IsLeaf() && n.Left.Left == nil && n.Left.Right == nil && n.Right.IsLeaf() && n.Right.Right == nil && n.Right.Left == nil) {
-		panic("Invalid branch")
+		panic(corruption("Invalid branch"))
 	}
 }
 
@@ -915,7 +1264,7 @@ func (t *Tree) CreateNodeFromSplit(parent *Node, split *SplitAxisOpt, side NodeS
 	}
 
 	if len(split.Items) < 1 {
-		panic("No Items")
+		panic(corruption("No Items"))
 	}
 
 	start := split.LeftStartIndex()
@@ -973,11 +1322,12 @@ func (t *Tree) ComputeVolume(n *Node) {
 		return
 	}
 
-	n.AssignVolume(b[0].Position(), b[0].Radius())
-
-	for _, e := range b {
-		n.ExpandVolume(e.Position(), e.Radius())
+	box := t.FatBox(b[0])
+	for _, e := range b[1:] {
+		box = box.Expand(t.FatBox(e))
 	}
+
+	n.Box = box
 }
 
 func (t *Tree) ChildRefit(cur *Node, propogate bool) {
@@ -1029,8 +1379,9 @@ func (t *Tree) SetDepth(n *Node, depth int) {
 	}
 
 	if !n.IsLeaf() {
-		if !n.IsValidBranch() {
-			panic("Bad branch")
+		// Only the node itself needs checking, we recurse into both children anyway
+		if !n.IsValidBranchNode() {
+			panic(corruption("Bad branch"))
 		}
 		t.SetDepth(n.Left, depth+1)
 		t.SetDepth(n.Right, depth+1)
@@ -1049,7 +1400,7 @@ func (t *Tree) AddObjectToNode(n *Node, e Entity, b BoundingBox, sa float64) {
 		newRightSA := leftSa + right.Box.Expand(b).SurfaceArea()
 		merged := left.Box.Expand(right.Box).SurfaceArea() + sa
 
-		if merged < math.Min(newLeftSA, newRightSA)*0.3 {
+		if merged < math.Min(newLeftSA, newRightSA)*t.pushdownFactor {
 			t.AddItemToBranch(n, e)
 			return
 		}
@@ -1067,66 +1418,105 @@ func (t *Tree) AddObjectToNode(n *Node, e Entity, b BoundingBox, sa float64) {
 	t.SplitIfNecessary(n)
 }
 
+// Add inserts e into the tree. It panics with ErrDuplicateEntity if e is already in the tree, or with
+// ErrCorruptTree; use TryAdd to get those as errors instead.
 func (t *Tree) Add(e Entity) {
-	box := BoxFromEntity(e)
+	if err := t.TryAdd(e); err != nil {
+		panic(err)
+	}
+}
+
+// TryAdd is Add returning ErrDuplicateEntity instead of panicking when e is already in the tree,
+// or ErrCorruptTree if the tree turns out to be inconsistent along the way
+func (t *Tree) TryAdd(e Entity) (err error) {
+	defer recoverCorrupt(&err)
+
+	if _, ok := t.GetLeaf(e); ok {
+		return ErrDuplicateEntity
+	}
+
+	box := t.FatBox(e)
 	t.AddObjectToNode(t.rootNode, e, box, box.SurfaceArea())
+
+	return nil
 }
 
+// Remove takes e out of the tree. It panics with ErrEntityNotFound if e isn't in the tree, or with ErrCorruptTree;
+// use TryRemove to get those as errors instead.
 func (t *Tree) Remove(e Entity) {
+	if err := t.TryRemove(e); err != nil {
+		panic(err)
+	}
+}
+
+// TryRemove is Remove returning ErrEntityNotFound instead of panicking when e isn't in the tree,
+// or ErrCorruptTree if the tree turns out to be inconsistent along the way
+func (t *Tree) TryRemove(e Entity) (err error) {
+	defer recoverCorrupt(&err)
+
 	l, ok := t.GetLeaf(e)
 
 	if !ok {
-		panic("Entity not found")
+		return ErrEntityNotFound
+	}
+
+	if !l.IsValidLeafNode() || !t.LeafHolds(l, e) {
+		return fmt.Errorf("%w: entity is mapped to node %d which doesn't hold it", ErrCorruptTree, l.NodeIndex)
 	}
 
 	t.RemoveItemFromNode(l, e)
-}
 
-type CustomDrawer interface {
-	DrawImage(*image.RGBA)
+	return nil
 }
 
-func (t *Tree) Image(path string) {
-	frame := image.NewRGBA(image.Rect(int(t.rootNode.Box.Min.X), int(t.rootNode.Box.Min.Y), int(t.rootNode.Box.Max.X)+1, int(t.rootNode.Box.Max.Y)+1))
-	draw.Draw(frame, frame.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
-	col := color.RGBA{255, 0, 0, 255}
+// LeafHolds reports whether e is in the bucket of leaf n
+func (t *Tree) LeafHolds(n *Node, e Entity) bool {
+	if n.BucketIndex < 1 || n.BucketIndex > len(t.Buckets) {
+		return false
+	}
 
-	HLine := func(x1, y, x2 int) {
-		for ; x1 <= x2; x1++ {
-			frame.Set(x1, y, col)
+	for _, entity := range t.Buckets[n.BucketIndex-1] {
+		if entity == e {
+			return true
 		}
 	}
+	return false
+}
 
-	// VLine draws a veritcal line
-	VLine := func(x, y1, y2 int) {
-		for ; y1 <= y2; y1++ {
-			frame.Set(x, y1, col)
-		}
+// CustomDrawer is implemented by entities that draw themselves when the tree is rasterized, using the transform
+// to find where they belong on the image
+type CustomDrawer interface {
+	DrawImage(img *image.RGBA, tf Transform)
+}
+
+// Image draws the tree onto the XY plane, one pixel per world unit, and saves it as a BMP at path.
+// Use Render or WritePNG to choose the area drawn and the size of the image.
+func (t *Tree) Image(path string) error {
+	tf := Transform{
+		Plane:   XY,
+		OriginX: math.Floor(t.rootNode.Box.Min.X),
+		OriginY: math.Floor(t.rootNode.Box.Min.Y),
+		Scale:   1,
 	}
 
-	// Rect draws a rectangle utilizing HLine() and VLine()
-	Rect := func(x1, y1, x2, y2 int) {
-		HLine(x1, y1, x2)
-		HLine(x1, y2, x2)
-		VLine(x1, y1, y2)
-		VLine(x2, y1, y2)
+	size := tf.Point(t.rootNode.Box.Max)
+
+	frame, err := newFrame(size.X+1, size.Y+1)
+	if err != nil {
+		return err
 	}
 
-	entities := t.Traverse(func(b BoundingBox) bool {
-		Rect(int(b.Min.X), int(b.Min.Y), int(b.Max.X), int(b.Max.Y))
-		return true
-	})
+	t.rasterize(frame, tf)
 
-	col = color.RGBA{0, 255, 0, 255}
-	for _, e := range entities {
-		if d, ok := e.(CustomDrawer); ok {
-			d.DrawImage(frame)
-			continue
-		}
-		b := BoxFromEntity(e)
-		Rect(int(b.Min.X), int(b.Min.Y), int(b.Max.X), int(b.Max.Y))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := bmp.Encode(f, frame); err != nil {
+		f.Close()
+		return err
 	}
 
-	f, _ := os.Create(path)
-	bmp.Encode(f, frame)
+	return f.Close()
 }
@@ -200,3 +200,258 @@ func BenchmarkRayTraversalLoop_1000(b *testing.B)    { loopTraversal(b, 1000) }
 func BenchmarkRayTraversalLoop_10000(b *testing.B)   { loopTraversal(b, 10000) }
 func BenchmarkRayTraversalLoop_100000(b *testing.B)  { loopTraversal(b, 100000) }
 func BenchmarkRayTraversalLoop_1000000(b *testing.B) { loopTraversal(b, 1000000) }
+
+func TestVisit(T *testing.T) {
+	t := generateTree(AMMOUNT)
+
+	gunshot := Ray{
+		Pos: Vec3{0, 0, 0},
+		Dir: Vec3{45, 45, 0},
+	}
+
+	expected := t.Traverse(gunshot.Intersects)
+
+	visited := 0
+	t.Visit(gunshot.Intersects, func(e Entity) bool {
+		visited++
+		return true
+	})
+
+	if visited != len(expected) {
+		T.Fatal("Visit/Traverse disagree", visited, len(expected))
+	}
+
+	visited = 0
+	t.Visit(gunshot.Intersects, func(e Entity) bool {
+		visited++
+		return visited < 3
+	})
+
+	if len(expected) >= 3 && visited != 3 {
+		T.Fatal("Visit did not stop early, visited", visited)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		t.Visit(gunshot.Intersects, func(e Entity) bool { return true })
+	})
+
+	if allocs > 0 {
+		T.Fatal("Visit allocated", allocs, "times")
+	}
+}
+
+func bvhVisit(b *testing.B, count int) {
+	t := generateTree(count)
+
+	gunshot := Ray{
+		Pos: Vec3{0, 0, 0},
+		Dir: Vec3{45, 45, 0},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		t.Visit(gunshot.Intersects, func(e Entity) bool { return true })
+	}
+}
+
+func BenchmarkRayVisitBVH_1000(b *testing.B)    { bvhVisit(b, 1000) }
+func BenchmarkRayVisitBVH_10000(b *testing.B)   { bvhVisit(b, 10000) }
+func BenchmarkRayVisitBVH_100000(b *testing.B)  { bvhVisit(b, 100000) }
+func BenchmarkRayVisitBVH_1000000(b *testing.B) { bvhVisit(b, 1000000) }
+
+func TestParallelTraverse(T *testing.T) {
+	t := generateTree(AMMOUNT)
+
+	gunshot := Ray{
+		Pos: Vec3{0, 0, 0},
+		Dir: Vec3{45, 45, 0},
+	}
+
+	expected := make(map[Entity]bool)
+	for _, e := range t.Traverse(gunshot.Intersects) {
+		expected[e] = true
+	}
+
+	for _, opts := range []ParallelOptions{{}, {Workers: 1}, {Workers: 4, SplitDepth: 1}, {Workers: 8, SplitDepth: 6}, {Workers: 3, SplitDepth: 64}} {
+		hits := t.ParallelTraverse(gunshot.Intersects, opts)
+
+		if len(hits) != len(expected) {
+			T.Fatal("Parallel/Serial disagree", opts, len(hits), len(expected))
+		}
+
+		for _, e := range hits {
+			if !expected[e] {
+				T.Fatal("Parallel traversal returned an unexpected entity", opts)
+			}
+		}
+	}
+
+	if len(t.ConcurrentTraverse(gunshot.Intersects)) != len(expected) {
+		T.Fatal("Concurrent/Serial disagree")
+	}
+}
+
+func concurrentTraversal(b *testing.B, count int) {
+	t := generateTree(count)
+
+	everything := func(BoundingBox) bool { return true }
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		t.ConcurrentTraverse(everything)
+	}
+}
+
+func BenchmarkConcurrentTraversal_10000(b *testing.B)  { concurrentTraversal(b, 10000) }
+func BenchmarkConcurrentTraversal_100000(b *testing.B) { concurrentTraversal(b, 100000) }
+
+func TestTryFindBetterNodeTie(T *testing.T) {
+	t := NewTree()
+	a := &Person{1, Vec3{-10, 0, 0}}
+	b := &Person{1, Vec3{10, 0, 0}}
+	t.Add(a)
+	t.Add(b)
+
+	// Halfway between the two leaves, descending either way costs exactly the same
+	a.position = Vec3{0, 0, 0}
+	leaf, _ := t.GetLeaf(a)
+
+	done := make(chan struct{})
+	go func() {
+		t.TryFindBetterNode(leaf, a)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		T.Fatal("TryFindBetterNode never settled on a side when both cost the same")
+	}
+}
+
+func TestRemoveCollapsesRoot(T *testing.T) {
+	rand.Seed(1313131313)
+
+	for round := 0; round < 50; round++ {
+		t := NewTree()
+
+		entities := make([]*Person, 2+rand.Intn(6))
+		for i := range entities {
+			entities[i] = &Person{1, Vec3{float64(rand.Intn(100)), float64(rand.Intn(100)), float64(rand.Intn(100))}}
+			t.Add(entities[i])
+		}
+
+		// Removing down to a single entity collapses the root over and over, whatever is left has to stay reachable
+		// with its links and depths intact
+		for len(entities) > 1 {
+			i := rand.Intn(len(entities))
+			t.Remove(entities[i])
+			entities = append(entities[:i], entities[i+1:]...)
+
+			if t.rootNode.Parent != nil || t.rootNode.Depth != 0 {
+				T.Fatal("Root wasn't collapsed properly", t.rootNode.Parent, t.rootNode.Depth)
+			}
+
+			stack := []*Node{t.rootNode}
+			for len(stack) > 0 {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+
+				if n.IsLeaf() {
+					continue
+				}
+				for _, c := range []*Node{n.Left, n.Right} {
+					if c.Parent != n || c.Depth != n.Depth+1 {
+						T.Fatal("Child links or depth broken after collapsing the root")
+					}
+					stack = append(stack, c)
+				}
+			}
+
+			if got := len(t.Traverse(func(BoundingBox) bool { return true })); got != len(entities) {
+				T.Fatal("Entities lost after collapsing the root", got, len(entities))
+			}
+		}
+	}
+}
+
+func TestMaxLeaves(T *testing.T) {
+	for _, maxLeaves := range []int{2, 4, 8} {
+		rand.Seed(1313131313)
+		o := DefaultTreeOptions()
+		o.MaxLeaves = maxLeaves
+		t, err := NewTreeWithOptions(o)
+		if err != nil {
+			T.Fatal(err)
+		}
+
+		entities := make([]*Person, 3000)
+		for i := range entities {
+			entities[i] = &Person{
+				size:     1,
+				position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+			}
+			t.Add(entities[i])
+		}
+
+		for _, p := range entities[:1000] {
+			t.Remove(p)
+		}
+		entities = entities[1000:]
+
+		moved := []Entity{}
+		for i, p := range entities {
+			p.position = p.position.Add(Vec3{float64(rand.Intn(50) - 25), float64(rand.Intn(50) - 25), float64(rand.Intn(50) - 25)})
+			if i%2 == 0 {
+				t.QueueForOptimize(p)
+			} else {
+				moved = append(moved, p)
+			}
+		}
+		t.Optimize()
+		t.Update(moved)
+
+		checkVolumes(T, t, t.rootNode)
+
+		leaves := 0
+		var count func(n *Node)
+		count = func(n *Node) {
+			if n.IsLeaf() {
+				leaves++
+				if t.ItemCount(n) > maxLeaves {
+					T.Fatal("Leaf holds", t.ItemCount(n), "items with max leaves of", maxLeaves)
+				}
+				return
+			}
+			count(n.Left)
+			count(n.Right)
+		}
+		count(t.rootNode)
+
+		if leaves >= len(entities) {
+			T.Fatal("Leaves aren't holding multiple items", leaves, len(entities))
+		}
+
+		test := func(b BoundingBox) bool {
+			return b.Intersects(BoundingBox{Vec3{100, 100, 100}, Vec3{600, 600, 600}})
+		}
+
+		expected := 0
+		for _, p := range entities {
+			if test(BoxFromEntity(p)) {
+				expected++
+			}
+		}
+
+		if got := len(t.Traverse(test)); got != expected {
+			T.Fatal("BVH/Loop disagree", maxLeaves, got, expected)
+		}
+
+		if got := len(t.Traverse(func(BoundingBox) bool { return true })); got != len(entities) {
+			T.Fatal("Entities lost", maxLeaves, got, len(entities))
+		}
+	}
+}
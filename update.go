@@ -0,0 +1,68 @@
+package dyntree
+
+// UpdateStats reports what Update had to do with the entities it was given
+type UpdateStats struct {
+	// Reinserted is how many entities were moved to a better node
+	Reinserted int
+	// Refit is how many entities stayed in their leaf and only needed its volume refit
+	Refit int
+	// Unchanged is how many entities still fit their stored volume and didn't need anything
+	Unchanged int
+}
+
+// Update brings the tree up to date with a batch of entities that have moved since they were last added or updated.
+// Entities that would be better off elsewhere are re-inserted, the remaining leaves are then refit and rotated in a
+// single bottom-up pass rather than walking to the root once per entity.
+func (t *Tree) Update(moved []Entity) UpdateStats {
+	stats, err := t.TryUpdate(moved)
+	if err != nil {
+		panic(err)
+	}
+
+	return stats
+}
+
+// TryUpdate is Update returning ErrCorruptTree instead of panicking if the tree turns out to be inconsistent along
+// the way, along with what it got done before then
+func (t *Tree) TryUpdate(moved []Entity) (stats UpdateStats, err error) {
+	defer recoverCorrupt(&err)
+
+	stay := make([]Entity, 0, len(moved))
+
+	for _, e := range moved {
+		n, ok := t.GetLeaf(e)
+		if !ok {
+			continue
+		}
+
+		if t.stillFits(n, e) {
+			stats.Unchanged++
+			continue
+		}
+
+		bn, ok, err := t.findBetterNode(n, e)
+		if err != nil {
+			return stats, err
+		}
+
+		if ok {
+			t.MoveItemBetweenNodes(n, bn, e)
+			stats.Reinserted++
+		} else {
+			stay = append(stay, e)
+		}
+	}
+
+	// Re-inserting can split or collapse leaves, so we only look up the leaves to refit once that's settled
+	dirty := make([]*Node, 0, len(stay))
+	for _, e := range stay {
+		if n, ok := t.GetLeaf(e); ok {
+			dirty = append(dirty, n)
+			stats.Refit++
+		}
+	}
+
+	t.RefitAndRotate(dirty)
+
+	return stats, nil
+}
@@ -0,0 +1,248 @@
+package dyntree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func checkVolumes(T *testing.T, t *Tree, n *Node) {
+	if n.IsLeaf() {
+		for _, e := range t.Buckets[n.BucketIndex-1] {
+			if l, _ := t.GetLeaf(e); l != n {
+				T.Fatal("Entity mapped to the wrong leaf")
+			}
+			if !n.Box.Contains(BoxFromEntity(e)) {
+				T.Fatal("Leaf doesn't contain its entity")
+			}
+		}
+		return
+	}
+
+	if !n.Box.Contains(n.Left.Box) || !n.Box.Contains(n.Right.Box) {
+		T.Fatal("Branch doesn't contain its children")
+	}
+	if n.Left.Parent != n || n.Right.Parent != n {
+		T.Fatal("Child isn't linked back to its parent")
+	}
+	if n.Left.Depth != n.Depth+1 || n.Right.Depth != n.Depth+1 {
+		T.Fatal("Child depth is inconsistent with its parent")
+	}
+
+	checkVolumes(T, t, n.Left)
+	checkVolumes(T, t, n.Right)
+}
+
+func TestUpdate(T *testing.T) {
+	rand.Seed(1313131313)
+	t := NewTree()
+
+	entities := make([]*Person, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		t.Add(entities[i])
+	}
+
+	for tick := 0; tick < 10; tick++ {
+		moved := make([]Entity, 0)
+		for _, p := range entities {
+			if rand.Intn(4) != 0 {
+				continue
+			}
+
+			// Mostly small steps with the occasional teleport
+			if rand.Intn(20) == 0 {
+				p.position = Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))}
+			} else {
+				p.position = p.position.Add(Vec3{rand.Float64()*4 - 2, rand.Float64()*4 - 2, rand.Float64()*4 - 2})
+			}
+			moved = append(moved, p)
+		}
+
+		stats := t.Update(moved)
+		if stats.Reinserted+stats.Refit+stats.Unchanged != len(moved) {
+			T.Fatal("Stats don't account for every moved entity", stats, len(moved))
+		}
+
+		checkVolumes(T, t, t.rootNode)
+	}
+
+	if len(t.Traverse(func(BoundingBox) bool { return true })) != AMMOUNT {
+		T.Fatal("Entities lost during update")
+	}
+
+	if stats := t.Update([]Entity{&Person{}}); stats.Reinserted+stats.Refit+stats.Unchanged != 0 {
+		T.Fatal("Unknown entity was updated")
+	}
+}
+
+func TestUpdateMargin(T *testing.T) {
+	rand.Seed(1313131313)
+	o := DefaultTreeOptions()
+	o.Margin = 2
+	t, err := NewTreeWithOptions(o)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	entities := make([]*Person, AMMOUNT)
+	moved := make([]Entity, AMMOUNT)
+	for i := range entities {
+		entities[i] = &Person{
+			size:     1,
+			position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+		}
+		moved[i] = entities[i]
+		t.Add(entities[i])
+	}
+
+	unchanged := 0
+	for tick := 0; tick < 10; tick++ {
+		for _, p := range entities {
+			p.position = p.position.Add(Vec3{rand.Float64() - 0.5, rand.Float64() - 0.5, rand.Float64() - 0.5})
+		}
+
+		unchanged += t.Update(moved).Unchanged
+		checkVolumes(T, t, t.rootNode)
+	}
+
+	if unchanged == 0 {
+		T.Fatal("Margin never saved an update")
+	}
+
+	test := func(b BoundingBox) bool {
+		return b.Intersects(BoundingBox{Vec3{200, 200, 200}, Vec3{400, 400, 400}})
+	}
+
+	expected := 0
+	for _, p := range entities {
+		if test(BoxFromEntity(p)) {
+			expected++
+		}
+	}
+
+	if got := len(t.Traverse(test)); got != expected {
+		T.Fatal("Traverse returned entities outside of the query", got, expected)
+	}
+
+	if got := len(t.ConcurrentTraverse(test)); got != expected {
+		T.Fatal("ConcurrentTraverse returned entities outside of the query", got, expected)
+	}
+
+	visited := 0
+	t.Visit(test, func(Entity) bool {
+		visited++
+		return true
+	})
+	if visited != expected {
+		T.Fatal("Visit returned entities outside of the query", visited, expected)
+	}
+
+	if _, err := NewTreeWithOptions(TreeOptions{Margin: -1}); err == nil {
+		T.Fatal("Negative margin was accepted")
+	}
+}
+
+func TestShrinkTightLeaf(T *testing.T) {
+	test := func(b BoundingBox) bool {
+		return b.Intersects(BoundingBox{Vec3{3, 3, 3}, Vec3{4, 4, 4}})
+	}
+
+	for _, update := range []func(t *Tree, e Entity){
+		func(t *Tree, e Entity) {
+			t.QueueForOptimize(e)
+			t.Optimize()
+		},
+		func(t *Tree, e Entity) {
+			t.Update([]Entity{e})
+		},
+	} {
+		t := NewTree()
+		shrinking := &Person{5, Vec3{0, 0, 0}}
+		t.Add(shrinking)
+		t.Add(&Person{1, Vec3{100, 0, 0}})
+
+		shrinking.size = 1
+		update(t, shrinking)
+		checkVolumes(T, t, t.rootNode)
+
+		if got := len(t.Traverse(test)); got != 0 {
+			T.Fatal("Traverse returned an entity that shrank out of the query", got)
+		}
+	}
+}
+
+type Projectile struct {
+	Person
+	velocity Vec3
+}
+
+func (p *Projectile) Velocity() Vec3 {
+	return p.velocity
+}
+
+func TestUpdateVelocityHorizon(T *testing.T) {
+	simulate := func(horizon float64) UpdateStats {
+		rand.Seed(1313131313)
+		o := DefaultTreeOptions()
+		o.VelocityHorizon = horizon
+		t, err := NewTreeWithOptions(o)
+		if err != nil {
+			T.Fatal(err)
+		}
+
+		projectiles := make([]*Projectile, 1000)
+		moved := make([]Entity, len(projectiles))
+		for i := range projectiles {
+			projectiles[i] = &Projectile{
+				Person: Person{
+					size:     1,
+					position: Vec3{float64(rand.Intn(1000)), float64(rand.Intn(1000)), float64(rand.Intn(1000))},
+				},
+				velocity: Vec3{float64(rand.Intn(20) - 10), float64(rand.Intn(20) - 10), 0},
+			}
+			moved[i] = projectiles[i]
+			t.Add(projectiles[i])
+		}
+
+		total := UpdateStats{}
+		for tick := 0; tick < 10; tick++ {
+			for _, p := range projectiles {
+				p.position = p.position.Add(p.velocity)
+			}
+
+			stats := t.Update(moved)
+			total.Reinserted += stats.Reinserted
+			total.Refit += stats.Refit
+			total.Unchanged += stats.Unchanged
+
+			checkVolumes(T, t, t.rootNode)
+		}
+
+		test := func(b BoundingBox) bool {
+			return b.Intersects(BoundingBox{Vec3{200, 200, 0}, Vec3{600, 600, 1000}})
+		}
+
+		expected := 0
+		for _, p := range projectiles {
+			if test(BoxFromEntity(p)) {
+				expected++
+			}
+		}
+
+		if got := len(t.Traverse(test)); got != expected {
+			T.Fatal("Traverse returned entities outside of the query", got, expected)
+		}
+
+		return total
+	}
+
+	still := simulate(0)
+	predicted := simulate(10)
+
+	if predicted.Unchanged <= still.Unchanged || predicted.Reinserted+predicted.Refit >= still.Reinserted+still.Refit {
+		T.Fatal("Velocity horizon didn't reduce tree updates", still, predicted)
+	}
+}
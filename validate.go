@@ -0,0 +1,156 @@
+package dyntree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists every broken invariant Validate found in a tree
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("dyntree: corrupt tree, %d violations: %s", len(e.Violations), strings.Join(e.Violations, "; "))
+}
+
+// Unwrap lets errors.Is match a ValidationError against ErrCorruptTree
+func (e *ValidationError) Unwrap() error {
+	return ErrCorruptTree
+}
+
+// Validate walks the whole tree checking its structural invariants, returning a *ValidationError describing
+// each one that's broken or nil if the tree is sound.
+func (t *Tree) Validate() error {
+	var violations []string
+	report := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	freeNodes := make(map[int]bool, len(t.unusedNodeIndicies))
+	for _, i := range t.unusedNodeIndicies {
+		if i < 0 || i >= len(t.nodes) {
+			report("free node %d is out of range", i)
+		} else if freeNodes[i] {
+			report("node %d is on the free list twice", i)
+		}
+		freeNodes[i] = true
+	}
+
+	freeBuckets := make(map[int]bool, len(t.unusedBucketIndicies))
+	for _, i := range t.unusedBucketIndicies {
+		if i < 1 || i > len(t.Buckets) {
+			report("free bucket %d is out of range", i)
+		} else if freeBuckets[i] {
+			report("bucket %d is on the free list twice", i)
+		}
+		freeBuckets[i] = true
+	}
+
+	if t.rootNode == nil {
+		return &ValidationError{[]string{"tree has no root"}}
+	}
+	if t.rootNode.Parent != nil {
+		report("root node %d has a parent", t.rootNode.NodeIndex)
+	}
+	if t.rootNode.Depth != 0 {
+		report("root node %d has depth %d", t.rootNode.NodeIndex, t.rootNode.Depth)
+	}
+
+	seen := make(map[*Node]bool)
+	found := make(map[Entity]*Node, len(t.leafs))
+	stack := []*Node{t.rootNode}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		// A node reachable twice means the links form a cycle or a shared subtree, don't walk it again
+		if seen[n] {
+			report("node %d is reachable more than once", n.NodeIndex)
+			continue
+		}
+		seen[n] = true
+
+		if n.NodeIndex < 0 || n.NodeIndex >= len(t.nodes) || t.nodes[n.NodeIndex] != n {
+			report("node %d isn't stored at its index", n.NodeIndex)
+		}
+		if freeNodes[n.NodeIndex] {
+			report("node %d is reachable but on the free list", n.NodeIndex)
+		}
+
+		if n.IsLeaf() {
+			t.validateLeaf(n, freeBuckets, found, report)
+			continue
+		}
+
+		if n.Left == nil || n.Right == nil {
+			report("branch %d is missing a child", n.NodeIndex)
+			continue
+		}
+
+		for _, c := range []*Node{n.Left, n.Right} {
+			if c.Parent != n {
+				report("node %d isn't linked back to its parent %d", c.NodeIndex, n.NodeIndex)
+			}
+			if c.Depth != n.Depth+1 {
+				report("node %d has depth %d under parent %d at depth %d", c.NodeIndex, c.Depth, n.NodeIndex, n.Depth)
+			}
+			if !n.Box.Contains(c.Box) {
+				report("branch %d doesn't contain its child %d", n.NodeIndex, c.NodeIndex)
+			}
+		}
+
+		stack = append(stack, n.Right, n.Left)
+	}
+
+	for e, n := range t.leafs {
+		if _, ok := found[e]; !ok {
+			report("entity %v is mapped to node %d but isn't in any reachable bucket", e, n.NodeIndex)
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{violations}
+	}
+
+	return nil
+}
+
+func (t *Tree) validateLeaf(n *Node, freeBuckets map[int]bool, found map[Entity]*Node, report func(string, ...interface{})) {
+	if n.Left != nil || n.Right != nil {
+		report("leaf %d has children", n.NodeIndex)
+	}
+
+	if n.BucketIndex < 1 || n.BucketIndex > len(t.Buckets) {
+		report("leaf %d has out of range bucket %d", n.NodeIndex, n.BucketIndex)
+		return
+	}
+	if freeBuckets[n.BucketIndex] {
+		report("leaf %d uses bucket %d which is on the free list", n.NodeIndex, n.BucketIndex)
+	}
+
+	bucket := t.Buckets[n.BucketIndex-1]
+
+	// The only leaf allowed to be empty is the root of an empty tree
+	if len(bucket) == 0 && n != t.rootNode {
+		report("leaf %d is empty", n.NodeIndex)
+	}
+
+	for _, e := range bucket {
+		if other, ok := found[e]; ok {
+			report("entity %v is in both leaf %d and leaf %d", e, other.NodeIndex, n.NodeIndex)
+		}
+		found[e] = n
+
+		if l, ok := t.leafs[e]; !ok {
+			report("entity %v in leaf %d isn't mapped to any leaf", e, n.NodeIndex)
+		} else if l != n {
+			report("entity %v in leaf %d is mapped to node %d", e, n.NodeIndex, l.NodeIndex)
+		}
+
+		if !n.Box.Contains(BoxFromEntity(e)) {
+			report("leaf %d doesn't contain entity %v", n.NodeIndex, e)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package dyntree
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestValidateRandomOperations(T *testing.T) {
+	rand.Seed(1919191919)
+
+	for _, maxLeaves := range []int{1, 4} {
+		t := NewTree(WithMaxLeaves(maxLeaves), WithMargin(0.5))
+		live := make([]*Person, 0)
+
+		for op := 0; op < 3000; op++ {
+			switch r := rand.Intn(10); {
+			case r < 4 || len(live) == 0:
+				p := &Person{size: 1, position: Vec3{float64(rand.Intn(200)), float64(rand.Intn(200)), float64(rand.Intn(200))}}
+				t.Add(p)
+				live = append(live, p)
+			case r < 6:
+				i := rand.Intn(len(live))
+				t.Remove(live[i])
+				live[i] = live[len(live)-1]
+				live = live[:len(live)-1]
+			case r < 8:
+				p := live[rand.Intn(len(live))]
+				p.position = p.position.Add(Vec3{rand.Float64()*10 - 5, rand.Float64()*10 - 5, rand.Float64()*10 - 5})
+				t.QueueForOptimize(p)
+			case r < 9:
+				t.OptimizeFor(0)
+			default:
+				t.Optimize()
+			}
+
+			if err := t.Validate(); err != nil {
+				T.Fatal("Tree corrupted after operation", op, err)
+			}
+		}
+
+		if len(t.leafs) != len(live) {
+			T.Fatal("Tree lost track of entities", len(t.leafs), len(live))
+		}
+	}
+}
+
+func TestValidateReportsCorruption(T *testing.T) {
+	t := BuildTree(randomEntities(100, 100))
+
+	if err := t.Validate(); err != nil {
+		T.Fatal("Fresh tree reported as corrupt", err)
+	}
+
+	t.rootNode.Left.Parent = t.rootNode.Right
+	t.FreeNode(t.rootNode.Right.Right)
+
+	leaf := t.rootNode.Left
+	for !leaf.IsLeaf() {
+		leaf = leaf.Left
+	}
+	t.Buckets[leaf.BucketIndex-1] = nil
+
+	var verr *ValidationError
+	if err := t.Validate(); !errors.As(err, &verr) {
+		T.Fatal("Expected a ValidationError, got", err)
+	}
+
+	if len(verr.Violations) < 3 {
+		T.Fatal("Expected every violation to be reported", verr.Violations)
+	}
+}
+
+func TestValidateFreeListRange(T *testing.T) {
+	t := BuildTree(randomEntities(100, 100))
+
+	t.unusedNodeIndicies = append(t.unusedNodeIndicies, len(t.nodes), -1)
+	t.unusedBucketIndicies = append(t.unusedBucketIndicies, 0, len(t.Buckets)+1)
+
+	var verr *ValidationError
+	if err := t.Validate(); !errors.As(err, &verr) {
+		T.Fatal("Expected a ValidationError, got", err)
+	}
+
+	if len(verr.Violations) != 4 {
+		T.Fatal("Expected every free list entry out of range to be reported", verr.Violations)
+	}
+}
+
+func TestValidateLeafWithChildren(T *testing.T) {
+	t := NewTree()
+	a, b := &Person{1, Vec3{0, 0, 0}}, &Person{1, Vec3{10, 0, 0}}
+	t.Add(a)
+	t.Add(b)
+
+	// A leaf still pointing at a branch, as if it had been split without giving up its bucket
+	leaf, _ := t.GetLeaf(a)
+	sibling := leaf.GetSibling()
+	leaf.Left, leaf.Right = sibling, sibling
+
+	var verr *ValidationError
+	if err := t.Validate(); !errors.As(err, &verr) {
+		T.Fatal("Expected a ValidationError, got", err)
+	}
+
+	for _, v := range verr.Violations {
+		if strings.Contains(v, "has children") {
+			return
+		}
+	}
+	T.Fatal("Leaf with children wasn't reported", verr.Violations)
+}